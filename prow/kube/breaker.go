@@ -0,0 +1,146 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops a Client from calling an api-server that keeps
+// failing. After Threshold calls in a row fail with transport errors or 5xx
+// responses, even after their retries, the circuit opens and calls fail
+// immediately with a CircuitOpenError. Once Cooldown has passed, a single
+// probe call is let through: if it succeeds the circuit closes, and if not
+// it opens for another Cooldown.
+//
+// A breaker may be shared by several clients, such as the copies made by
+// WithNamespace, which then open and close together. A breaker whose
+// Threshold isn't positive is disabled.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	// Clock times the cooldown. If nil, the real clock is used.
+	Clock Clock
+
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+	// probing is set while the single probe call is in flight.
+	probing bool
+}
+
+// NewCircuitBreaker returns a closed breaker. If threshold isn't positive it
+// returns nil, which lets every call through.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// CircuitOpenError is returned without making a request while the circuit is
+// open.
+type CircuitOpenError struct {
+	// Until is when the next probe request will be let through.
+	Until time.Time
+}
+
+func (e CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open until %s after repeated api-server failures", e.Until.Format(time.RFC3339))
+}
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+// breakerOutcome is how a call let through by the breaker turned out.
+type breakerOutcome int
+
+const (
+	// breakerAbandoned calls, such as those whose context was canceled,
+	// say nothing about the api-server's health.
+	breakerAbandoned breakerOutcome = iota
+	breakerSucceeded
+	breakerFailed
+)
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Clock != nil {
+		return b.Clock.Now()
+	}
+	return time.Now()
+}
+
+func (b *CircuitBreaker) state() breakerState {
+	switch {
+	case b.failures < b.Threshold:
+		return breakerClosed
+	case b.probing || b.now().Sub(b.openedAt) < b.Cooldown:
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+// allow returns a CircuitOpenError if a call must not be made. Otherwise the
+// caller must report how the call went by passing the returned probe flag,
+// which says whether the call is the probe, to done. A nil or disabled
+// breaker allows everything.
+func (b *CircuitBreaker) allow() (probe bool, err error) {
+	if b == nil || b.Threshold <= 0 {
+		return false, nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state() {
+	case breakerOpen:
+		return false, CircuitOpenError{Until: b.openedAt.Add(b.Cooldown)}
+	case breakerHalfOpen:
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// done records the outcome of a call that allow let through. Only the probe
+// itself ends probing, so calls let through before the circuit opened can't
+// let a second probe through when they finish.
+func (b *CircuitBreaker) done(probe bool, outcome breakerOutcome) {
+	if b == nil || b.Threshold <= 0 {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch outcome {
+	case breakerSucceeded:
+		b.failures = 0
+	case breakerFailed:
+		b.failures++
+		if b.failures >= b.Threshold {
+			b.openedAt = b.now()
+		}
+	}
+}
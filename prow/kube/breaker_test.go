@@ -0,0 +1,148 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failing := true
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.NoRetry = true
+	clock := &fakeClock{now: time.Now()}
+	b := NewCircuitBreaker(2, 50*time.Millisecond)
+	b.Clock = clock
+	c.CircuitBreaker = b
+	expectState := func(desc string, expected breakerState) {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if s := b.state(); s != expected {
+			t.Fatalf("%s: expected the circuit to be %s, got %s", desc, expected, s)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetPod("po"); err == nil {
+			t.Fatal("Expected error from a failing server.")
+		}
+	}
+	expectState("after two failures", breakerOpen)
+	if _, err := c.GetPod("po"); err == nil {
+		t.Fatal("Expected error while the circuit is open.")
+	} else if _, ok := err.(CircuitOpenError); !ok {
+		t.Errorf("Expected a CircuitOpenError, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected no request while the circuit is open, got %d requests", calls)
+	}
+
+	clock.step(60 * time.Millisecond)
+	expectState("after the cooldown", breakerHalfOpen)
+	if _, err := c.GetPod("po"); err == nil {
+		t.Fatal("Expected the probe to fail.")
+	}
+	expectState("after a failed probe", breakerOpen)
+
+	clock.step(60 * time.Millisecond)
+	failing = false
+	if _, err := c.GetPod("po"); err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	expectState("after a successful probe", breakerClosed)
+	if calls != 4 {
+		t.Errorf("Expected one request per probe, got %d requests", calls)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := NewCircuitBreaker(1, time.Millisecond)
+	b.Clock = clock
+	// A slow call that was let through before the circuit opened.
+	slow, err := b.allow()
+	if err != nil {
+		t.Fatalf("Expected a closed circuit to allow calls, got %v", err)
+	}
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatalf("Expected a closed circuit to allow calls, got %v", err)
+	}
+	b.done(probe, breakerFailed)
+	clock.step(5 * time.Millisecond)
+	if probe, err = b.allow(); err != nil || !probe {
+		t.Fatalf("Expected a probe to be allowed, got %t %v", probe, err)
+	}
+	if _, err := b.allow(); err == nil {
+		t.Error("Expected other calls to be refused while the probe is in flight.")
+	}
+	// The slow call finishing doesn't end the probe.
+	b.done(slow, breakerAbandoned)
+	if _, err := b.allow(); err == nil {
+		t.Error("Expected calls to be refused until the probe finishes.")
+	}
+	// A probe that is abandoned lets another through.
+	b.done(probe, breakerAbandoned)
+	if probe, err = b.allow(); err != nil || !probe {
+		t.Errorf("Expected a new probe after the first was abandoned, got %t %v", probe, err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	if b := NewCircuitBreaker(0, time.Minute); b != nil {
+		t.Errorf("Expected no breaker for a zero threshold, got %+v", b)
+	}
+	b := &CircuitBreaker{}
+	for i := 0; i < 3; i++ {
+		probe, err := b.allow()
+		if err != nil || probe {
+			t.Fatalf("Expected a zero breaker to allow every call, got %t %v", probe, err)
+		}
+		b.done(probe, breakerFailed)
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "reason": "NotFound", "code": 404}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.CircuitBreaker = NewCircuitBreaker(1, time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetPod("po"); err == nil {
+			t.Fatal("Expected a not found error.")
+		} else if _, ok := err.(CircuitOpenError); ok {
+			t.Fatal("A 404 shouldn't open the circuit.")
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"sync"
+	"time"
+)
+
+// CachingClient is a Client that serves repeated GetConfigMap and GetSecret
+// calls from memory for up to TTL after fetching them, for callers that read
+// the same objects on a hot path and can tolerate slightly stale data. Only
+// successful reads are cached. Entries expire by the wrapped client's Clock.
+// Every other method goes straight to the api-server, and writes made through
+// it don't invalidate the cache.
+type CachingClient struct {
+	*Client
+	TTL time.Duration
+
+	lock sync.Mutex
+	// The caches are keyed by name. The wrapped client's namespace is fixed.
+	configMaps map[string]cacheEntry
+	secrets    map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	data    map[string]string
+	meta    ObjectMeta
+	expires time.Time
+}
+
+// NewCachingClient wraps c with a cache whose entries live for ttl.
+func NewCachingClient(c *Client, ttl time.Duration) *CachingClient {
+	return &CachingClient{
+		Client:     c,
+		TTL:        ttl,
+		configMaps: map[string]cacheEntry{},
+		secrets:    map[string]cacheEntry{},
+	}
+}
+
+// GetConfigMap returns the named config map, from the cache if possible.
+func (c *CachingClient) GetConfigMap(name string) (ConfigMap, error) {
+	if e, ok := c.lookup(c.configMaps, name); ok {
+		return ConfigMap{Metadata: e.meta, Data: e.data}, nil
+	}
+	cm, err := c.Client.GetConfigMap(name)
+	if err != nil {
+		return cm, err
+	}
+	c.store(c.configMaps, name, cm.Metadata, cm.Data)
+	return cm, nil
+}
+
+// GetSecret returns the named secret, from the cache if possible.
+func (c *CachingClient) GetSecret(name string) (Secret, error) {
+	if e, ok := c.lookup(c.secrets, name); ok {
+		return Secret{Metadata: e.meta, Data: e.data}, nil
+	}
+	s, err := c.Client.GetSecret(name)
+	if err != nil {
+		return s, err
+	}
+	c.store(c.secrets, name, s.Metadata, s.Data)
+	return s, nil
+}
+
+// lookup returns an unexpired entry, with its data and metadata copied so
+// that callers can't modify the cache.
+func (c *CachingClient) lookup(cache map[string]cacheEntry, name string) (cacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := cache[name]
+	if !ok || c.clock().Now().After(e.expires) {
+		return cacheEntry{}, false
+	}
+	e.data = copyData(e.data)
+	e.meta = copyMeta(e.meta)
+	return e, true
+}
+
+func (c *CachingClient) store(cache map[string]cacheEntry, name string, meta ObjectMeta, data map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cache[name] = cacheEntry{
+		data:    copyData(data),
+		meta:    copyMeta(meta),
+		expires: c.clock().Now().Add(c.TTL),
+	}
+}
+
+// copyMeta copies everything meta refers to, so that the copy shares nothing
+// with it.
+func copyMeta(meta ObjectMeta) ObjectMeta {
+	meta.Labels = copyData(meta.Labels)
+	meta.Annotations = copyData(meta.Annotations)
+	if meta.OwnerReferences != nil {
+		refs := make([]OwnerReference, len(meta.OwnerReferences))
+		for i, ref := range meta.OwnerReferences {
+			ref.Controller = copyBool(ref.Controller)
+			ref.BlockOwnerDeletion = copyBool(ref.BlockOwnerDeletion)
+			refs[i] = ref
+		}
+		meta.OwnerReferences = refs
+	}
+	meta.CreationTimestamp = copyTime(meta.CreationTimestamp)
+	meta.DeletionTimestamp = copyTime(meta.DeletionTimestamp)
+	return meta
+}
+
+func copyBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	cp := *b
+	return &cp
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	cp := *t
+	return &cp
+}
+
+func copyData(data map[string]string) map[string]string {
+	if data == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(data))
+	for k, v := range data {
+		cp[k] = v
+	}
+	return cp
+}
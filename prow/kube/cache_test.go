@@ -0,0 +1,89 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCachingClient(t *testing.T) {
+	requests := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		switch r.URL.Path {
+		case "/api/v1/namespaces/ns/configmaps/config":
+			fmt.Fprint(w, `{"metadata": {"labels": {"app": "a"}, "annotations": {"note": "a"}, "ownerReferences": [{"name": "a", "controller": true}], "creationTimestamp": "2017-01-01T00:00:00Z", "deletionTimestamp": "2017-01-02T00:00:00Z"}, "data": {"config.yaml": "a"}}`)
+		case "/api/v1/namespaces/ns/secrets/token":
+			fmt.Fprint(w, `{"data": {"token": "dG9r"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "reason": "NotFound", "code": 404}`)
+		}
+	}))
+	defer ts.Close()
+	clock := &fakeClock{now: time.Now()}
+	c := NewCachingClient(getClient(ts.URL), 100*time.Millisecond)
+	c.Clock = clock
+
+	for i := 0; i < 3; i++ {
+		cm, err := c.GetConfigMap("config")
+		if err != nil {
+			t.Fatalf("Didn't expect error: %v", err)
+		}
+		meta := cm.Metadata
+		if cm.Data["config.yaml"] != "a" || meta.Labels["app"] != "a" || meta.Annotations["note"] != "a" ||
+			len(meta.OwnerReferences) != 1 || meta.OwnerReferences[0].Name != "a" || !*meta.OwnerReferences[0].Controller ||
+			meta.CreationTimestamp.Year() != 2017 || meta.DeletionTimestamp.Year() != 2017 {
+			t.Errorf("Wrong config map: %+v", cm)
+		}
+		// Changing the result mustn't change the cache.
+		cm.Data["config.yaml"] = "changed"
+		meta.Labels["app"] = "changed"
+		meta.Annotations["note"] = "changed"
+		meta.OwnerReferences[0].Name = "changed"
+		*meta.OwnerReferences[0].Controller = false
+		*meta.CreationTimestamp = time.Time{}
+		*meta.DeletionTimestamp = time.Time{}
+		if _, err := c.GetSecret("token"); err != nil {
+			t.Fatalf("Didn't expect error: %v", err)
+		}
+		if _, err := c.GetConfigMap("missing"); err == nil {
+			t.Error("Expected error for a missing config map.")
+		}
+	}
+	if n := requests["/api/v1/namespaces/ns/configmaps/config"]; n != 1 {
+		t.Errorf("Expected one config map request within the TTL, got %d", n)
+	}
+	if n := requests["/api/v1/namespaces/ns/secrets/token"]; n != 1 {
+		t.Errorf("Expected one secret request within the TTL, got %d", n)
+	}
+	if n := requests["/api/v1/namespaces/ns/configmaps/missing"]; n != 3 {
+		t.Errorf("Expected errors not to be cached, got %d requests", n)
+	}
+
+	clock.step(150 * time.Millisecond)
+	if _, err := c.GetConfigMap("config"); err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if n := requests["/api/v1/namespaces/ns/configmaps/config"]; n != 2 {
+		t.Errorf("Expected a refetch after the TTL, got %d requests", n)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.json")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/ns/pods":
+			fmt.Fprint(w, `{"items": [{"metadata": {"name": "a"}}, {"metadata": {"name": "b"}}]}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/ns/pods":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"metadata": {"name": "c", "uid": "1234"}}`)
+		case r.URL.Path == "/api/v1/namespaces/ns/secrets/token":
+			io.Copy(w, r.Body)
+		case r.URL.Path == "/api/v1/namespaces/ns/secrets":
+			fmt.Fprint(w, `{"items": [{"metadata": {"name": "token"}, "data": {"token": "hunter2"}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "reason": "NotFound", "code": 404}`)
+		}
+	}))
+	session := func(c *Client) []string {
+		var results []string
+		pods, err := c.ListPods(map[string]string{"app": "deck"})
+		results = append(results, fmt.Sprintf("%v %v", pods, err))
+		pod, err := c.CreatePod(Pod{Metadata: ObjectMeta{Name: "c"}})
+		results = append(results, fmt.Sprintf("%v %v", pod, err))
+		pod, err = c.GetPod("missing")
+		results = append(results, fmt.Sprintf("%v %v", pod, err))
+		return results
+	}
+
+	recording := getClient(ts.URL)
+	recording.client = newHTTPClient(ClientConfig{
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return NewRecorder(rt, path)
+		},
+	}, nil)
+	recorded := session(recording)
+	if err := recording.ReplaceSecret("token", Secret{Data: map[string]string{"token": "hunter2"}}); err != nil {
+		t.Errorf("Didn't expect error replacing a secret: %v", err)
+	}
+	if secrets, err := recording.ListSecrets(nil); err != nil || len(secrets) != 1 || secrets[0].Data["token"] != "hunter2" {
+		t.Errorf("Recording mustn't change what the caller gets, got %v %v", secrets, err)
+	}
+	ts.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Couldn't read cassette: %v", err)
+	}
+	if strings.Contains(string(b), "abcd") {
+		t.Error("The cassette mustn't contain the bearer token.")
+	}
+	if strings.Contains(string(b), "hunter2") {
+		t.Error("The cassette mustn't contain secret data.")
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatalf("Couldn't load cassette: %v", err)
+	}
+	// The server is gone, so any request that reaches the network fails.
+	replaying := getClient(ts.URL)
+	replaying.client = &http.Client{Transport: replayer}
+	replayed := session(replaying)
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("Replay differs from recording:\n%v\n%v", recorded, replayed)
+	}
+	if err := replaying.ReplaceSecret("token", Secret{Data: map[string]string{"token": "hunter2"}}); err != nil {
+		t.Errorf("Didn't expect error replaying a secret replace: %v", err)
+	}
+	if secrets, err := replaying.ListSecrets(nil); err != nil || len(secrets) != 1 || secrets[0].Data["token"] != "REDACTED" {
+		t.Errorf("Expected the replayed secret to be redacted, got %v %v", secrets, err)
+	}
+	if _, err := replaying.WithoutRetries().GetPod("extra"); err == nil {
+		t.Error("Expected error once the cassette is exhausted.")
+	}
+}
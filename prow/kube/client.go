@@ -18,14 +18,24 @@ package kube
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -33,22 +43,132 @@ const (
 	inClusterBaseURL = "https://kubernetes"
 	maxRetries       = 8
 	retryDelay       = 2 * time.Second
+
+	defaultPollInterval = 5 * time.Second
+	// defaultFieldManager identifies the client's writes to the api-server's
+	// field ownership tracking.
+	defaultFieldManager = "prow"
+	// defaultUserAgent names the client in api-server audit logs when no
+	// component has identified itself.
+	defaultUserAgent = "prow"
+
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
+
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 60 * time.Second
+
+	// Match client-go, which pings idle HTTP/2 connections so that
+	// requests don't hang on a connection to an api-server that is gone.
+	defaultHTTP2ReadIdleTimeout = 30 * time.Second
+	defaultHTTP2PingTimeout     = 15 * time.Second
+	// defaultMaxResponseBytes is generous enough for a list of many
+	// thousands of pods.
+	defaultMaxResponseBytes = 512 << 20
+
+	// createPodsConcurrency bounds the requests CreatePods makes at once.
+	createPodsConcurrency = 5
+	// getLogsConcurrency bounds the requests GetLogs makes at once.
+	getLogsConcurrency = 5
+
+	// maxDrainBackoff caps how long DrainPod waits between evictions.
+	maxDrainBackoff = time.Minute
+
+	conflictRetries    = 5
+	conflictRetryDelay = 10 * time.Millisecond
 )
 
 type Logger interface {
 	Printf(s string, v ...interface{})
 }
 
+// Clock tells the time and waits for it to pass. Tests can substitute one
+// that advances instantly.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
 // Client interacts with the Kubernetes api-server.
 type Client struct {
 	// If Logger is non-nil, log all method calls with it.
 	Logger Logger
+	// PollInterval is how often the Wait* methods check on an object. If
+	// zero, defaultPollInterval is used.
+	PollInterval time.Duration
+	// RequestTimeout bounds each individual HTTP request, including reading
+	// the response body, but not the retry loop as a whole. If zero,
+	// requests have no timeout.
+	RequestTimeout time.Duration
+	// MaxElapsedTime, if positive, bounds the time a request spends on
+	// retries. Once waiting for the next attempt would exceed it, the
+	// request fails with a RetryTimeoutError however many retries remain.
+	// Use RequestTimeout to bound the attempts themselves.
+	MaxElapsedTime time.Duration
+	// If RetryOn5xx is true, requests that fail with a 500, 502, 503, or 504
+	// are retried with the same backoff as transport failures.
+	RetryOn5xx bool
+	// MaxResponseBytes caps the size of a response body. Larger responses
+	// fail with a ResponseTooLargeError. If zero, defaultMaxResponseBytes is
+	// used.
+	MaxResponseBytes int64
+	// MaxRequestBytes, if positive, caps the size of a request body. Larger
+	// requests fail with a RequestTooLargeError before they are sent, which
+	// is clearer than the api-server rejecting an object too big for etcd,
+	// whose limit is about 1.5MB.
+	MaxRequestBytes int64
+	// If NoRetry is true, each request is attempted exactly once. Retrying a
+	// create after an ambiguous failure, such as a dropped connection, can
+	// create the object twice if the first attempt actually reached the
+	// api-server, so callers that cannot tolerate duplicates should disable
+	// retries and decide for themselves how to recover.
+	NoRetry bool
+	// CircuitBreaker, if non-nil, stops the client from calling an
+	// api-server that keeps failing.
+	CircuitBreaker *CircuitBreaker
+	// FieldManager is sent with every create, replace, and patch, so that
+	// the api-server records the client as the manager of the fields it
+	// sets and audit logs name it. If empty, defaultFieldManager is used.
+	FieldManager string
+	// RetriesExhausted, if non-nil, is called once for each request that
+	// is still failing after its last attempt, or once MaxElapsedTime is
+	// up, with its HTTP method and path. Counting these separates calls that
+	// gave up from calls that succeeded after a retry.
+	RetriesExhausted func(method, path string)
+	// UserAgent is sent with every request so that api-server audit logs
+	// show which component made it, such as "plank/v20170601". If empty,
+	// defaultUserAgent is used.
+	UserAgent string
+	// WarningHandler, if non-nil, is called with the text of each Warning
+	// header the api-server sends, such as notices about deprecated APIs.
+	// If nil, warnings are logged with Logger.
+	WarningHandler func(warning string)
 
 	baseURL   string
 	client    *http.Client
 	token     string
 	namespace string
-	fake      bool
+	fake      *fakeResponder
+	discovery *discoveryCache
+
+	// Clock times retries, backoff, and polling. If nil, the real clock is
+	// used.
+	Clock Clock
+
+	// initialBackoff overrides retryDelay if non-zero.
+	initialBackoff time.Duration
+}
+
+func (c *Client) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return realClock{}
 }
 
 func (c *Client) log(methodName string, args ...interface{}) {
@@ -62,102 +182,857 @@ func (c *Client) log(methodName string, args ...interface{}) {
 	c.Logger.Printf("%s(%s)", methodName, strings.Join(as, ", "))
 }
 
-type ConflictError error
+// debugLogger is implemented by loggers with levels, such as logrus.
+type debugLogger interface {
+	Debugf(s string, v ...interface{})
+}
+
+// debugf logs at debug level if the Logger supports it, and with Printf
+// otherwise.
+func (c *Client) debugf(s string, v ...interface{}) {
+	switch l := c.Logger.(type) {
+	case nil:
+	case debugLogger:
+		l.Debugf(s, v...)
+	default:
+		l.Printf(s, v...)
+	}
+}
+
+// ConflictError is returned when the api-server rejects a request with a 409
+// Conflict, usually because the object was modified since it was read.
+type ConflictError struct {
+	Body string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("body: %s", e.Body)
+}
+
+// IsConflict returns true if err is a ConflictError.
+func IsConflict(err error) bool {
+	_, ok := err.(ConflictError)
+	return ok
+}
+
+// AlreadyExistsError is returned when creating an object that already
+// exists. The api-server also answers these with a 409, but unlike a
+// ConflictError, retrying won't help.
+type AlreadyExistsError struct {
+	Body string
+}
+
+func (e AlreadyExistsError) Error() string {
+	return fmt.Sprintf("already exists: %s", e.Body)
+}
+
+// IsAlreadyExists returns true if err is an AlreadyExistsError.
+func IsAlreadyExists(err error) bool {
+	_, ok := err.(AlreadyExistsError)
+	return ok
+}
+
+// RetryOnConflict calls fn until it returns something other than a
+// ConflictError, backing off between attempts. It gives up and returns the
+// last ConflictError after a few attempts. Use it to wrap read-modify-write
+// sequences so that each attempt rereads the object.
+func RetryOnConflict(fn func() error) error {
+	return retryOnConflict(realClock{}, fn)
+}
+
+// RetryOnConflict is like the package-level RetryOnConflict, but backs off
+// on the client's Clock.
+func (c *Client) RetryOnConflict(fn func() error) error {
+	return retryOnConflict(c.clock(), fn)
+}
+
+func retryOnConflict(clock Clock, fn func() error) error {
+	backoff := conflictRetryDelay
+	for retries := 0; ; retries++ {
+		if err := fn(); !IsConflict(err) || retries == conflictRetries-1 {
+			return err
+		}
+		<-clock.After(backoff)
+		backoff *= 2
+	}
+}
+
+// DisruptionBudgetError is returned when evicting a pod would violate a
+// PodDisruptionBudget. The eviction may succeed if retried later.
+type DisruptionBudgetError struct {
+	Pod     string
+	Message string
+}
+
+func (e DisruptionBudgetError) Error() string {
+	return fmt.Sprintf("cannot evict pod %s: %s", e.Pod, e.Message)
+}
+
+// DrainTimeoutError is returned by DrainPod when its context is done while
+// disruption budgets are still blocking the eviction. Err is the last
+// DisruptionBudgetError.
+type DrainTimeoutError struct {
+	Pod string
+	Err error
+}
+
+func (e DrainTimeoutError) Error() string {
+	return fmt.Sprintf("timed out draining pod %s: %v", e.Pod, e.Err)
+}
+
+// ForbiddenError is returned when the api-server refuses a request with a
+// 403 Forbidden, usually because the client's service account lacks an RBAC
+// permission. Retrying won't help until the permission is granted. Verb and
+// Resource are parsed from the message and are empty if it doesn't name them.
+type ForbiddenError struct {
+	Verb     string
+	Resource string
+	Message  string
+}
+
+func (e ForbiddenError) Error() string {
+	if e.Verb != "" && e.Resource != "" {
+		return fmt.Sprintf("missing RBAC permission to %s %s: %s", e.Verb, e.Resource, e.Message)
+	}
+	return fmt.Sprintf("forbidden: %s", e.Message)
+}
+
+// IsForbidden returns true if err is a ForbiddenError.
+func IsForbidden(err error) bool {
+	_, ok := err.(ForbiddenError)
+	return ok
+}
+
+// forbiddenRE matches the api-server's description of a denied request, such
+// as `cannot list resource "pods" in API group ""` or, from older versions,
+// `cannot list pods in the namespace "default"`.
+var forbiddenRE = regexp.MustCompile(`cannot (\S+) (?:resource "([^"]+)"|(\S+))`)
+
+func newForbiddenError(message string) ForbiddenError {
+	e := ForbiddenError{Message: message}
+	if m := forbiddenRE.FindStringSubmatch(message); m != nil {
+		e.Verb = m[1]
+		e.Resource = m[2]
+		if e.Resource == "" {
+			e.Resource = m[3]
+		}
+	}
+	return e
+}
+
+// NoMatchingPodError is returned by GetSingletonPod when no pod matches.
+type NoMatchingPodError struct {
+	Selector string
+}
+
+func (e NoMatchingPodError) Error() string {
+	return fmt.Sprintf("no pod matches %q", e.Selector)
+}
+
+// MultipleMatchingPodsError is returned by GetSingletonPod when more than one
+// pod matches.
+type MultipleMatchingPodsError struct {
+	Selector string
+	Count    int
+}
+
+func (e MultipleMatchingPodsError) Error() string {
+	return fmt.Sprintf("%d pods match %q, expected one", e.Count, e.Selector)
+}
+
+// UnexpectedPhaseError is returned when waiting for a pod to reach a phase
+// and it instead reaches a different terminal phase.
+type UnexpectedPhaseError struct {
+	Pod   string
+	Want  PodPhase
+	Phase PodPhase
+}
+
+func (e UnexpectedPhaseError) Error() string {
+	return fmt.Sprintf("pod %s reached phase %s while waiting for %s", e.Pod, e.Phase, e.Want)
+}
+
+// RetryTimeoutError is returned when a request is still failing once the
+// client's MaxElapsedTime is up. Err is the last attempt's failure.
+type RetryTimeoutError struct {
+	Attempts int
+	Elapsed  time.Duration
+	Err      error
+}
+
+func (e RetryTimeoutError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts in %v: %v", e.Attempts, e.Elapsed, e.Err)
+}
+
+// ResponseTooLargeError is returned when a response body exceeds the
+// client's MaxResponseBytes.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds the limit of %d bytes", e.Limit)
+}
+
+// RequestTooLargeError is returned without sending a request whose body
+// exceeds the client's MaxRequestBytes.
+type RequestTooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e RequestTooLargeError) Error() string {
+	return fmt.Sprintf("request body of %d bytes exceeds the limit of %d bytes", e.Size, e.Limit)
+}
+
+// MarshalError is returned without sending a request whose body cannot be
+// encoded as JSON, such as one containing a channel.
+type MarshalError struct {
+	Method string
+	Path   string
+	Err    error
+}
+
+func (e MarshalError) Error() string {
+	return fmt.Sprintf("cannot encode body of %s %s: %v", e.Method, e.Path, e.Err)
+}
+
+func (e MarshalError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is returned for non-2xx responses whose body is a Status,
+// other than those with their own error types such as ForbiddenError.
+type StatusError struct {
+	Code    int
+	Reason  string
+	Message string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("response has status %d (%s): %s", e.Code, e.Reason, e.Message)
+}
+
+// IsNotFound returns true if err is a StatusError for a missing object.
+func IsNotFound(err error) bool {
+	se, ok := err.(StatusError)
+	return ok && se.Code == http.StatusNotFound
+}
+
+// requestIDHeader carries the request ID. The api-server uses it as the audit
+// ID, so it shows up in audit logs.
+const requestIDHeader = "Audit-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying id. Requests made with it use id
+// instead of generating a new one, which lets a single ID be traced through
+// several components.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// newRequestID returns a random UUID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
 
 type request struct {
+	// If ctx is nil, context.Background() is used.
+	ctx         context.Context
 	method      string
 	path        string
 	query       map[string]string
 	requestBody interface{}
+	// contentType overrides the default Content-Type, which is
+	// application/strategic-merge-patch+json for patches and
+	// application/json otherwise.
+	contentType string
+	// stream marks long-lived responses, such as followed logs, which are
+	// exempt from RequestTimeout and MaxResponseBytes.
+	stream bool
 }
 
 func (c *Client) request(r *request, ret interface{}) error {
-	out, err := c.requestRetry(r)
-	if err != nil {
-		return err
-	}
-	if ret != nil {
-		if err := json.Unmarshal(out, ret); err != nil {
+	return c.readResponse(r, func(body io.Reader) error {
+		out, err := ioutil.ReadAll(body)
+		if err != nil || ret == nil {
 			return err
 		}
-	}
-	return nil
+		return json.Unmarshal(out, ret)
+	})
+}
+
+// requestDecode is like request but decodes the response directly from the
+// body rather than buffering it first, which keeps peak memory down for large
+// list responses.
+func (c *Client) requestDecode(r *request, ret interface{}) error {
+	return c.readResponse(r, func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(ret)
+	})
 }
 
-// Retry on transport failures. Does not retry on 500s.
 func (c *Client) requestRetry(r *request) ([]byte, error) {
-	if c.fake {
-		return []byte("{}"), nil
-	}
-	var resp *http.Response
-	var err error
-	backoff := retryDelay
-	for retries := 0; retries < maxRetries; retries++ {
-		resp, err = c.doRequest(r.method, r.path, r.query, r.requestBody)
-		if err == nil {
-			break
-		}
+	var out []byte
+	err := c.readResponse(r, func(body io.Reader) error {
+		var err error
+		out, err = ioutil.ReadAll(body)
+		return err
+	})
+	return out, err
+}
 
-		time.Sleep(backoff)
-		backoff *= 2
+// readResponse makes r and passes the response body to read.
+func (c *Client) readResponse(r *request, read func(io.Reader) error) error {
+	_, err := c.requestRetryRead(r, read)
+	return err
+}
+
+// isTruncated reports whether err means that a response body ended early.
+// An empty body isn't counted, since retrying is unlikely to fill it.
+func isTruncated(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) && syntaxErr.Offset > 0 && syntaxErr.Error() == "unexpected end of JSON input"
+}
+
+// Retry on transport failures, and on 5xx server errors if RetryOn5xx is set.
+// On success the caller must close the returned body.
+func (c *Client) requestRetryStream(r *request) (io.ReadCloser, error) {
+	return c.requestRetryRead(r, nil)
+}
+
+// requestRetryRead makes r, retrying as requestRetryStream does. If read is
+// not nil it is passed the body of a successful response, and a GET whose body
+// turns out to be truncated, as when the connection is reset partway through a
+// large list, is retried from the same budget as a transport failure.
+func (c *Client) requestRetryRead(r *request, read func(io.Reader) error) (io.ReadCloser, error) {
+	// Paths are built with the client's namespace, so an empty one yields
+	// paths like /api/v1/namespaces//pods that fail confusingly.
+	if strings.Contains(r.path, "/namespaces//") {
+		return nil, fmt.Errorf("empty namespace in request path %s", r.path)
+	}
+	switch r.method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		if r.requestBody == nil {
+			return nil, fmt.Errorf("%s %s has no request body", r.method, r.path)
+		}
+	}
+	if c.fake != nil {
+		body, err := c.fake.respond(r)
+		if err != nil || read == nil {
+			return body, err
+		}
+		defer body.Close()
+		return nil, read(body)
+	}
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
+	// Every attempt shares one request ID so that retries can be correlated.
+	id, ok := RequestIDFromContext(ctx)
+	if !ok {
+		id = newRequestID()
+		ctx = WithRequestID(ctx, id)
+	}
+	probe, err := c.CircuitBreaker.allow()
 	if err != nil {
 		return nil, err
 	}
+	outcome := breakerAbandoned
+	defer func() { c.CircuitBreaker.done(probe, outcome) }()
+	backoff := c.retryDelay()
+	start := c.clock().Now()
+	attempts := maxRetries
+	if c.NoRetry {
+		attempts = 1
+	}
+	for retries := 0; ; retries++ {
+		last := retries == attempts-1
+		resp, err := c.doRequest(ctx, r)
+		reason := err
+		if err == nil && c.RetryOn5xx && isRetryableStatus(resp.StatusCode) {
+			reason = fmt.Errorf("status %q", resp.Status)
+		} else if err != nil && !isRetryable(err) {
+			c.debugf("Request %s %s (ID %s) failed permanently on attempt %d: %v", r.method, r.path, id, retries+1, err)
+			return nil, err
+		}
+		if reason != nil && last {
+			c.debugf("Request %s %s (ID %s) attempt %d/%d failed, giving up: %v", r.method, r.path, id, retries+1, attempts, reason)
+			c.retriesExhausted(r)
+			if err != nil {
+				outcome = breakerFailed
+				return nil, err
+			}
+			// The last 5xx response is turned into an error below.
+		}
+		if reason == nil || last {
+			if resp.StatusCode >= 500 {
+				outcome = breakerFailed
+			} else {
+				outcome = breakerSucceeded
+			}
+			body, err := c.responseBody(r, resp)
+			if err != nil || read == nil {
+				return body, err
+			}
+			err = read(body)
+			body.Close()
+			if !isTruncated(err) || r.method != http.MethodGet {
+				return nil, err
+			}
+			if last {
+				c.debugf("Response to %s %s (ID %s) was truncated on attempt %d/%d, giving up: %v", r.method, r.path, id, retries+1, attempts, err)
+				c.retriesExhausted(r)
+				outcome = breakerFailed
+				return nil, err
+			}
+			outcome = breakerAbandoned
+			reason = fmt.Errorf("truncated response: %v", err)
+		} else if err == nil {
+			resp.Body.Close()
+		}
+		if elapsed := c.clock().Now().Sub(start); c.MaxElapsedTime > 0 && elapsed+backoff > c.MaxElapsedTime {
+			c.debugf("Request %s %s (ID %s) attempt %d/%d failed, giving up after %v: %v", r.method, r.path, id, retries+1, attempts, elapsed, reason)
+			c.retriesExhausted(r)
+			outcome = breakerFailed
+			return nil, RetryTimeoutError{Attempts: retries + 1, Elapsed: elapsed, Err: reason}
+		}
+		c.debugf("Request %s %s (ID %s) attempt %d/%d failed, retrying in %v: %v", r.method, r.path, id, retries+1, attempts, backoff, reason)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.clock().After(backoff):
+		}
+		backoff *= 2
+	}
+}
 
+// responseBody reports warnings from resp and returns its body if it has a 2xx
+// status, or else an error describing the status.
+func (c *Client) responseBody(r *request, resp *http.Response) (io.ReadCloser, error) {
+	for _, h := range resp.Header[http.CanonicalHeaderKey("Warning")] {
+		for _, warning := range parseWarnings(h) {
+			if c.WarningHandler != nil {
+				c.WarningHandler(warning)
+			} else if c.Logger != nil {
+				c.Logger.Printf("Warning from api-server for %s %s: %s", r.method, r.path, warning)
+			}
+		}
+	}
+	if !r.stream {
+		limit := c.MaxResponseBytes
+		if limit == 0 {
+			limit = defaultMaxResponseBytes
+		}
+		resp.Body = &limitedReadCloser{ReadCloser: resp.Body, limit: limit, remaining: limit}
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp.Body, nil
+	}
 	defer resp.Body.Close()
 	rb, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
+	var status Status
+	isStatus := json.Unmarshal(rb, &status) == nil && status.Kind == "Status"
 	if resp.StatusCode == 409 {
-		return nil, ConflictError(fmt.Errorf("body: %s", string(rb)))
-	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, fmt.Errorf("response has status \"%s\" and body \"%s\"", resp.Status, string(rb))
+		if isStatus && status.Reason == "AlreadyExists" {
+			return nil, AlreadyExistsError{Body: string(rb)}
+		}
+		return nil, ConflictError{Body: string(rb)}
+	}
+	if isStatus {
+		if resp.StatusCode == http.StatusForbidden {
+			return nil, newForbiddenError(status.Message)
+		}
+		if status.Code == 0 {
+			status.Code = resp.StatusCode
+		}
+		return nil, StatusError{Code: status.Code, Reason: status.Reason, Message: status.Message}
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, newForbiddenError(string(rb))
+	}
+	// Paths the api-server doesn't serve at all, such as an unknown group
+	// version, get a plain-text 404 rather than a Status.
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, StatusError{Code: http.StatusNotFound, Reason: "NotFound", Message: strings.TrimSpace(string(rb))}
+	}
+	return nil, fmt.Errorf("response has status \"%s\" and body \"%s\"", resp.Status, string(rb))
+}
+
+// limitedReadCloser fails with a ResponseTooLargeError rather than silently
+// truncating the body once more than limit bytes are read.
+type limitedReadCloser struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only fail if there really is more to read.
+		var b [1]byte
+		n, err := l.ReadCloser.Read(b[:])
+		if n > 0 {
+			return 0, ResponseTooLargeError{Limit: l.limit}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// parseWarnings returns the text of each warning in a Warning header, which
+// looks like `299 - "first", 299 - "second"`. Malformed input is skipped.
+func parseWarnings(h string) []string {
+	var warnings []string
+	for {
+		// Skip the warn-code and warn-agent to the quoted warn-text.
+		start := strings.Index(h, `"`)
+		if start == -1 {
+			return warnings
+		}
+		var text []byte
+		i := start + 1
+		for ; i < len(h) && h[i] != '"'; i++ {
+			if h[i] == '\\' && i+1 < len(h) {
+				i++
+			}
+			text = append(text, h[i])
+		}
+		if i >= len(h) {
+			return warnings
+		}
+		warnings = append(warnings, string(text))
+		// Skip the optional quoted warn-date up to the next warning.
+		h = h[i+1:]
+		next := strings.Index(h, ",")
+		if next == -1 {
+			return warnings
+		}
+		if q := strings.Index(h, `"`); q != -1 && q < next {
+			end := strings.Index(h[q+1:], `"`)
+			if end == -1 {
+				return warnings
+			}
+			h = h[q+1+end+1:]
+			if next = strings.Index(h, ","); next == -1 {
+				return warnings
+			}
+		}
+		h = h[next+1:]
+	}
+}
+
+// isRetryable returns false for transport errors that retrying cannot fix:
+// cancellation, certificate verification failures, and requests that could
+// not be constructed. Anything else, such as a refused connection, a timeout,
+// or an unexpected EOF, is assumed to be transient. A per-request timeout
+// counts as transient, since cancellation of the caller's context is checked
+// separately.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.Op == "parse" {
+		return false
+	}
+	var (
+		verifyErr           *tls.CertificateVerificationError
+		unknownAuthorityErr x509.UnknownAuthorityError
+		certInvalidErr      x509.CertificateInvalidError
+		hostnameErr         x509.HostnameError
+	)
+	if errors.As(err, &verifyErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &certInvalidErr) || errors.As(err, &hostnameErr) {
+		return false
+	}
+	var (
+		unsupportedTypeErr  *json.UnsupportedTypeError
+		unsupportedValueErr *json.UnsupportedValueError
+		marshalerErr        *json.MarshalerError
+	)
+	if errors.As(err, &unsupportedTypeErr) || errors.As(err, &unsupportedValueErr) ||
+		errors.As(err, &marshalerErr) {
+		return false
+	}
+	var tooLargeErr RequestTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		return false
+	}
+	return true
+}
+
+// pollInterval returns how often the Wait* methods check on an object.
+func (c *Client) pollInterval() time.Duration {
+	if c.PollInterval != 0 {
+		return c.PollInterval
+	}
+	return defaultPollInterval
+}
+
+// retriesExhausted reports that r has failed for good after retrying.
+func (c *Client) retriesExhausted(r *request) {
+	if c.RetriesExhausted != nil {
+		c.RetriesExhausted(r.method, r.path)
+	}
+}
+
+// userAgent returns the User-Agent to send with requests.
+func (c *Client) userAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	return defaultUserAgent
+}
+
+// fieldManager returns the field manager to send with writes.
+func (c *Client) fieldManager() string {
+	if c.FieldManager != "" {
+		return c.FieldManager
+	}
+	return defaultFieldManager
+}
+
+// retryDelay returns the delay before the first retry of a failed request.
+func (c *Client) retryDelay() time.Duration {
+	if c.initialBackoff != 0 {
+		return c.initialBackoff
+	}
+	return retryDelay
+}
+
+// isRetryableStatus returns true for server errors that are usually
+// transient, such as the api-server briefly losing its etcd connection.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
 	}
-	return rb, nil
+	return false
 }
 
-func (c *Client) doRequest(method, urlPath string, query map[string]string, body interface{}) (*http.Response, error) {
-	url := c.baseURL + urlPath
+func (c *Client) doRequest(ctx context.Context, r *request) (*http.Response, error) {
+	url := c.baseURL + r.path
 	var buf io.Reader
-	if body != nil {
-		b, err := json.Marshal(body)
+	if r.requestBody != nil {
+		b, err := json.Marshal(r.requestBody)
 		if err != nil {
-			return nil, err
+			return nil, MarshalError{Method: r.method, Path: r.path, Err: err}
+		}
+		if c.MaxRequestBytes > 0 && int64(len(b)) > c.MaxRequestBytes {
+			return nil, RequestTooLargeError{Size: int64(len(b)), Limit: c.MaxRequestBytes}
 		}
 		buf = bytes.NewBuffer(b)
 	}
-	req, err := http.NewRequest(method, url, buf)
+	cancel := context.CancelFunc(func() {})
+	if c.RequestTimeout > 0 && !r.stream {
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, url, buf)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
+	// Leave Accept-Encoding unset so that the transport asks for gzip and
+	// transparently decompresses the response, which shrinks large lists.
 	req.Header.Set("Authorization", "Bearer "+c.token)
-	if method == http.MethodPatch {
+	req.Header.Set("User-Agent", c.userAgent())
+	if id, ok := RequestIDFromContext(ctx); ok {
+		req.Header.Set(requestIDHeader, id)
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	} else if r.method == http.MethodPatch {
 		req.Header.Set("Content-Type", "application/strategic-merge-patch+json")
 	} else {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
 	q := req.URL.Query()
-	for k, v := range query {
+	for k, v := range r.query {
 		q.Add(k, v)
 	}
+	switch r.method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		if q.Get("fieldManager") == "" {
+			q.Set("fieldManager", c.fieldManager())
+		}
+	}
 	req.URL.RawQuery = q.Encode()
 
-	return c.client.Do(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The transport only decompresses gzip for us if it asked for gzip
+	// itself, which it doesn't if Accept-Encoding was set elsewhere, for
+	// instance by a wrapped transport.
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil && err != io.EOF {
+			resp.Body.Close()
+			cancel()
+			return nil, fmt.Errorf("decompressing response: %v", err)
+		}
+		resp.Body = &gzipReadCloser{zr: zr, ReadCloser: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+	// The timeout must keep applying while the caller reads the body, so
+	// only release the context once the body is closed.
+	resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// gzipReadCloser reads the decompressed contents of a gzipped body. A nil zr
+// means the body was empty.
+type gzipReadCloser struct {
+	io.ReadCloser
+	zr *gzip.Reader
 }
 
-// NewFakeClient creates a client that doesn't do anything.
-func NewFakeClient() *Client {
-	return &Client{
-		namespace: "default",
-		fake:      true,
+func (g *gzipReadCloser) Read(p []byte) (int, error) {
+	if g.zr == nil {
+		return 0, io.EOF
+	}
+	return g.zr.Read(p)
+}
+
+// cancelReadCloser cancels a request's context when its body is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// ClientConfig tunes the transport of a Client. The zero value is usable.
+type ClientConfig struct {
+	// DialTimeout bounds establishing a TCP connection to the api-server.
+	// If zero, defaultDialTimeout is used.
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period of api-server connections. If
+	// zero, defaultKeepAlive is used.
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake. If zero,
+	// defaultTLSHandshakeTimeout is used.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for response headers once the
+	// request is sent. It doesn't limit reading the body, so watches and
+	// log streams are unaffected. If zero, defaultResponseHeaderTimeout is
+	// used.
+	ResponseHeaderTimeout time.Duration
+	// HTTP2ReadIdleTimeout is how long an HTTP/2 connection may receive
+	// nothing before it is checked with a ping. If zero,
+	// defaultHTTP2ReadIdleTimeout is used.
+	HTTP2ReadIdleTimeout time.Duration
+	// HTTP2PingTimeout is how long to wait for a ping's answer before
+	// closing the connection as dead. If zero, defaultHTTP2PingTimeout is
+	// used.
+	HTTP2PingTimeout time.Duration
+	// WrapTransport, if non-nil, wraps the transport the client builds, for
+	// instance to add tracing or metrics. The wrapped transport still
+	// carries the client's TLS config, and the client sets the
+	// Authorization header before the request reaches it.
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+	// UserAgent sets the client's UserAgent.
+	UserAgent string
+}
+
+// newTransport builds the transport described by cfg.
+// newDialer returns the dialer that newTransport connects with.
+func newDialer(cfg ClientConfig) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	if dialer.Timeout == 0 {
+		dialer.Timeout = defaultDialTimeout
+	}
+	if dialer.KeepAlive == 0 {
+		dialer.KeepAlive = defaultKeepAlive
+	}
+	return dialer
+}
+
+func newTransport(cfg ClientConfig, tlsConfig *tls.Config) *http.Transport {
+	tr := &http.Transport{
+		DialContext:           newDialer(cfg).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+	}
+	if tr.TLSHandshakeTimeout == 0 {
+		tr.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if tr.ResponseHeaderTimeout == 0 {
+		tr.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+	}
+	// A custom dialer and TLS config turn off HTTP/2 unless it is forced.
+	tr.ForceAttemptHTTP2 = true
+	tr.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout: cfg.HTTP2ReadIdleTimeout,
+		PingTimeout:     cfg.HTTP2PingTimeout,
+	}
+	if tr.HTTP2.SendPingTimeout == 0 {
+		tr.HTTP2.SendPingTimeout = defaultHTTP2ReadIdleTimeout
+	}
+	if tr.HTTP2.PingTimeout == 0 {
+		tr.HTTP2.PingTimeout = defaultHTTP2PingTimeout
+	}
+	return tr
+}
+
+// newHTTPClient builds the HTTP client described by cfg.
+func newHTTPClient(cfg ClientConfig, tlsConfig *tls.Config) *http.Client {
+	var rt http.RoundTripper = newTransport(cfg, tlsConfig)
+	if cfg.WrapTransport != nil {
+		rt = cfg.WrapTransport(rt)
 	}
+	return &http.Client{Transport: rt}
 }
 
 // NewClientInCluster creates a Client that works from within a pod.
 func NewClientInCluster(namespace string) (*Client, error) {
+	return NewClientInClusterWithConfig(namespace, ClientConfig{})
+}
+
+// NewClientInClusterWithConfig is like NewClientInCluster but tunes the
+// transport with cfg.
+func NewClientInClusterWithConfig(namespace string, cfg ClientConfig) (*Client, error) {
+	if namespace == "" {
+		return nil, errors.New("namespace must not be empty")
+	}
 	tokenFile := "/var/run/secrets/kubernetes.io/serviceaccount/token"
 	token, err := ioutil.ReadFile(tokenFile)
 	if err != nil {
@@ -173,52 +1048,373 @@ func NewClientInCluster(namespace string) (*Client, error) {
 	cp := x509.NewCertPool()
 	cp.AppendCertsFromPEM(certData)
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-			RootCAs:    cp,
-		},
-	}
-	c := &http.Client{Transport: tr}
+	c := newHTTPClient(cfg, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    cp,
+	})
 	return &Client{
 		baseURL:   inClusterBaseURL,
 		client:    c,
 		token:     string(token),
 		namespace: namespace,
+		discovery: newDiscoveryCache(),
+		UserAgent: cfg.UserAgent,
 	}, nil
 }
 
-func labelsToSelector(labels map[string]string) string {
-	var sel []string
-	for k, v := range labels {
-		sel = append(sel, fmt.Sprintf("%s = %s", k, v))
+// Close closes the client's idle keep-alive connections. Short-lived tools
+// should call it before exiting. It is safe to call more than once, and does
+// nothing for a fake client.
+func (c *Client) Close() {
+	if c.client == nil {
+		return
+	}
+	tr := c.client.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	if ci, ok := tr.(interface {
+		CloseIdleConnections()
+	}); ok {
+		ci.CloseIdleConnections()
 	}
-	return strings.Join(sel, ",")
 }
 
-func (c *Client) GetPod(name string) (Pod, error) {
-	c.log("GetPod", name)
-	var retPod Pod
-	err := c.request(&request{
+// The *RawResource methods reach arbitrary api-server resources, such as
+// CRDs, using the client's retry, TLS, and auth machinery. Callers are
+// responsible for constructing correct paths, for instance
+// "/apis/prow.k8s.io/v1/namespaces/default/prowjobs/foo". The client's
+// namespace is not applied. Responses are unmarshaled into into, unless it
+// is nil.
+
+// GetRawResource issues a GET to path with the given query parameters.
+func (c *Client) GetRawResource(path string, query map[string]string, into interface{}) error {
+	c.log("GetRawResource", path, query)
+	return c.request(&request{
 		method: http.MethodGet,
-		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+		path:   path,
+		query:  query,
+	}, into)
+}
+
+// CreateRawResource POSTs body to the collection at path.
+func (c *Client) CreateRawResource(path string, body, into interface{}) error {
+	c.log("CreateRawResource", path)
+	return c.request(&request{
+		method:      http.MethodPost,
+		path:        path,
+		requestBody: body,
+	}, into)
+}
+
+// PatchRawResource sends body to path as a JSON merge patch, since the
+// api-server rejects strategic merge patches on custom resources.
+func (c *Client) PatchRawResource(path string, body, into interface{}) error {
+	c.log("PatchRawResource", path)
+	return c.request(&request{
+		method:      http.MethodPatch,
+		path:        path,
+		requestBody: body,
+		contentType: "application/merge-patch+json",
+	}, into)
+}
+
+// DeleteRawResource issues a DELETE to path.
+func (c *Client) DeleteRawResource(path string) error {
+	c.log("DeleteRawResource", path)
+	return c.request(&request{
+		method: http.MethodDelete,
+		path:   path,
+	}, nil)
+}
+
+// WithNamespace returns a copy of c that operates in namespace ns. The copy
+// shares c's HTTP client, credentials, and logger, and changes to c's
+// exported fields after the call do not affect it. If ns is empty, the
+// copy's namespaced requests fail.
+func (c *Client) WithNamespace(ns string) *Client {
+	nc := *c
+	nc.namespace = ns
+	return &nc
+}
+
+// WithoutRetries returns a copy of c with NoRetry set, for making individual
+// non-idempotent calls such as c.WithoutRetries().CreatePod(p).
+func (c *Client) WithoutRetries() *Client {
+	nc := *c
+	nc.NoRetry = true
+	return &nc
+}
+
+func labelsToSelector(labels map[string]string) string {
+	var sel []string
+	for k, v := range labels {
+		sel = append(sel, fmt.Sprintf("%s = %s", k, v))
+	}
+	return strings.Join(sel, ",")
+}
+
+// fieldsToSelector builds a field selector such as "status.phase=Succeeded".
+// Keys are sorted so that the selector is deterministic.
+func fieldsToSelector(fields map[string]string) string {
+	var sel []string
+	for k, v := range fields {
+		sel = append(sel, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(sel)
+	return strings.Join(sel, ",")
+}
+
+// query translates the set fields of o into query parameters.
+func (o GetOptions) query() map[string]string {
+	q := map[string]string{}
+	if o.ResourceVersion != "" {
+		q["resourceVersion"] = o.ResourceVersion
+	}
+	return q
+}
+
+// query translates the set fields of o into query parameters.
+func (o ListOptions) query() map[string]string {
+	q := map[string]string{}
+	if o.LabelSelector != "" {
+		q["labelSelector"] = o.LabelSelector
+	}
+	if o.FieldSelector != "" {
+		q["fieldSelector"] = o.FieldSelector
+	}
+	if o.Limit > 0 {
+		q["limit"] = strconv.FormatInt(o.Limit, 10)
+	}
+	if o.Continue != "" {
+		q["continue"] = o.Continue
+	}
+	if o.ResourceVersion != "" {
+		q["resourceVersion"] = o.ResourceVersion
+	}
+	if o.TimeoutSeconds > 0 {
+		q["timeoutSeconds"] = strconv.FormatInt(o.TimeoutSeconds, 10)
+	}
+	return q
+}
+
+func (c *Client) GetPod(name string) (Pod, error) {
+	c.log("GetPod", name)
+	var retPod Pod
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+	}, &retPod)
+	return retPod, err
+}
+
+// GetPodWithOptions is like GetPod, tuned by opts.
+func (c *Client) GetPodWithOptions(name string, opts GetOptions) (Pod, error) {
+	c.log("GetPodWithOptions", name, opts)
+	var retPod Pod
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+		query:  opts.query(),
 	}, &retPod)
 	return retPod, err
 }
 
+// GetPodPhase returns the current phase of the named pod.
+func (c *Client) GetPodPhase(name string) (PodPhase, error) {
+	pod, err := c.GetPod(name)
+	if err != nil {
+		return "", err
+	}
+	return pod.Status.Phase, nil
+}
+
+// GetPodStatus returns a summary of the named pod's status.
+func (c *Client) GetPodStatus(name string) (PodStatusSummary, error) {
+	pod, err := c.GetPod(name)
+	if err != nil {
+		return PodStatusSummary{}, err
+	}
+	return pod.Summary(), nil
+}
+
+// WaitForPodPhase polls the named pod until it reaches phase and returns it.
+// A pod that succeeds while waiting for PodRunning must have run, so it is
+// returned too. If the pod reaches a different terminal phase first, it
+// returns an UnexpectedPhaseError. If ctx is done first, it returns ctx.Err().
+func (c *Client) WaitForPodPhase(ctx context.Context, name string, phase PodPhase) (Pod, error) {
+	c.log("WaitForPodPhase", name, phase)
+	interval := c.pollInterval()
+	for {
+		var pod Pod
+		err := c.request(&request{
+			ctx:    ctx,
+			method: http.MethodGet,
+			path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+		}, &pod)
+		if err != nil {
+			return pod, err
+		}
+		switch pod.Status.Phase {
+		case phase:
+			return pod, nil
+		case PodSucceeded:
+			if phase == PodRunning {
+				return pod, nil
+			}
+			return pod, UnexpectedPhaseError{Pod: name, Want: phase, Phase: pod.Status.Phase}
+		case PodFailed:
+			return pod, UnexpectedPhaseError{Pod: name, Want: phase, Phase: pod.Status.Phase}
+		}
+		select {
+		case <-ctx.Done():
+			return pod, ctx.Err()
+		case <-c.clock().After(interval):
+		}
+	}
+}
+
 func (c *Client) ListPods(labels map[string]string) ([]Pod, error) {
-	c.log("ListPods", labels)
+	pl, err := c.ListPodsWithOptions(ListOptions{LabelSelector: labelsToSelector(labels)})
+	return pl.Items, err
+}
+
+// ListPodsWithOptions lists the pods selected by opts.
+func (c *Client) ListPodsWithOptions(opts ListOptions) (PodList, error) {
+	c.log("ListPodsWithOptions", opts)
+	var pl PodList
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods", c.namespace),
+		query:  opts.query(),
+	}, &pl)
+	return pl, err
+}
+
+// GetSingletonPod returns the only pod matching labels. If there is none it
+// returns a NoMatchingPodError, and if there are several it returns a
+// MultipleMatchingPodsError.
+func (c *Client) GetSingletonPod(labels map[string]string) (Pod, error) {
+	pods, err := c.ListPods(labels)
+	if err != nil {
+		return Pod{}, err
+	}
+	switch len(pods) {
+	case 0:
+		return Pod{}, NoMatchingPodError{Selector: labelsToSelector(labels)}
+	case 1:
+		return pods[0], nil
+	default:
+		return Pod{}, MultipleMatchingPodsError{Selector: labelsToSelector(labels), Count: len(pods)}
+	}
+}
+
+// ListPodsWithAnnotations lists pods matching labels and then keeps those
+// whose annotations include every key and value in annotations. The
+// api-server can't select on annotations, so that filtering happens here,
+// after the whole label-selected list is fetched.
+func (c *Client) ListPodsWithAnnotations(labels, annotations map[string]string) ([]Pod, error) {
+	pods, err := c.ListPods(labels)
+	if err != nil {
+		return nil, err
+	}
+	var matched []Pod
+	for _, pod := range pods {
+		if hasAnnotations(pod.Metadata, annotations) {
+			matched = append(matched, pod)
+		}
+	}
+	return matched, nil
+}
+
+// ListPodsByOwner lists pods matching labels and then keeps those with an
+// owner reference to the object with UID ownerUID. Owner references are
+// authoritative where labels such as job-name may not be, but the api-server
+// can't select on them, so that filtering happens here, after the whole
+// label-selected list is fetched.
+func (c *Client) ListPodsByOwner(ownerUID string, labels map[string]string) ([]Pod, error) {
+	pods, err := c.ListPods(labels)
+	if err != nil {
+		return nil, err
+	}
+	var owned []Pod
+	for _, pod := range pods {
+		for _, ref := range pod.Metadata.OwnerReferences {
+			if ref.UID == ownerUID {
+				owned = append(owned, pod)
+				break
+			}
+		}
+	}
+	return owned, nil
+}
+
+func hasAnnotations(meta ObjectMeta, annotations map[string]string) bool {
+	for k, v := range annotations {
+		if got, ok := meta.Annotations[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ListPodsAllNamespaces is like ListPods but lists pods in every namespace.
+// Each pod's namespace is set in its metadata.
+func (c *Client) ListPodsAllNamespaces(labels map[string]string) ([]Pod, error) {
+	c.log("ListPodsAllNamespaces", labels)
 	var pl struct {
 		Items []Pod `json:"items"`
 	}
-	err := c.request(&request{
+	err := c.requestDecode(&request{
 		method: http.MethodGet,
-		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods", c.namespace),
+		path:   "/api/v1/pods",
 		query:  map[string]string{"labelSelector": labelsToSelector(labels)},
 	}, &pl)
 	return pl.Items, err
 }
 
+// ListPodsByField lists pods matching both labels and the field selector
+// built from fields, for instance {"status.phase": "Succeeded"}.
+func (c *Client) ListPodsByField(labels, fields map[string]string) ([]Pod, error) {
+	c.log("ListPodsByField", labels, fields)
+	var pl struct {
+		Items []Pod `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods", c.namespace),
+		query: map[string]string{
+			"labelSelector": labelsToSelector(labels),
+			"fieldSelector": fieldsToSelector(fields),
+		},
+	}, &pl)
+	return pl.Items, err
+}
+
+// ListPodsOnNode lists the pods matching labels that are scheduled on the
+// named node, for instance to find the pods lost with a failed node.
+func (c *Client) ListPodsOnNode(nodeName string, labels map[string]string) ([]Pod, error) {
+	return c.ListPodsByField(labels, map[string]string{"spec.nodeName": nodeName})
+}
+
+// DeletePodsByField deletes every pod matching both labels and the field
+// selector built from fields in a single request. At least one label or
+// field is required so that this never deletes every pod in the namespace.
+func (c *Client) DeletePodsByField(labels, fields map[string]string) error {
+	c.log("DeletePodsByField", labels, fields)
+	if len(labels) == 0 && len(fields) == 0 {
+		return errors.New("refusing to delete all pods: no labels or fields given")
+	}
+	return c.request(&request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods", c.namespace),
+		query: map[string]string{
+			"labelSelector": labelsToSelector(labels),
+			"fieldSelector": fieldsToSelector(fields),
+		},
+	}, nil)
+}
+
 func (c *Client) DeletePod(name string) error {
 	c.log("DeletePod", name)
 	return c.request(&request{
@@ -227,6 +1423,154 @@ func (c *Client) DeletePod(name string) error {
 	}, nil)
 }
 
+// EvictPod evicts the named pod. Unlike DeletePod, this respects the pod's
+// disruption budgets: if evicting it now would violate one, it returns a
+// DisruptionBudgetError.
+func (c *Client) EvictPod(name string) error {
+	c.log("EvictPod", name)
+	return c.evictPod(context.Background(), name)
+}
+
+func (c *Client) evictPod(ctx context.Context, name string) error {
+	err := c.request(&request{
+		ctx:    ctx,
+		method: http.MethodPost,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/eviction", c.namespace, name),
+		requestBody: Eviction{
+			APIVersion: "policy/v1",
+			Kind:       "Eviction",
+			Metadata:   ObjectMeta{Name: name, Namespace: c.namespace},
+		},
+	}, nil)
+	if se, ok := err.(StatusError); ok && se.Code == http.StatusTooManyRequests {
+		return DisruptionBudgetError{Pod: name, Message: se.Message}
+	}
+	return err
+}
+
+// DrainPod evicts the named pod, retrying with backoff for as long as
+// disruption budgets block it. If ctx is done first, it returns a
+// DrainTimeoutError. Any other failure is returned as is. A pod that is
+// already gone counts as drained.
+func (c *Client) DrainPod(ctx context.Context, name string) error {
+	c.log("DrainPod", name)
+	backoff := c.pollInterval()
+	for {
+		err := c.evictPod(ctx, name)
+		if _, ok := err.(DisruptionBudgetError); !ok {
+			if IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return DrainTimeoutError{Pod: name, Err: err}
+		case <-c.clock().After(backoff):
+		}
+		if backoff *= 2; backoff > maxDrainBackoff {
+			backoff = maxDrainBackoff
+		}
+	}
+}
+
+// ReplacePod replaces the named pod with p. Most of a pod's spec is immutable
+// once created, so attempts to change it fail with a 422 StatusError.
+func (c *Client) ReplacePod(name string, p Pod) (Pod, error) {
+	c.log("ReplacePod", name, p)
+	var retPod Pod
+	err := c.request(&request{
+		method:      http.MethodPut,
+		path:        fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+		requestBody: &p,
+	}, &retPod)
+	return retPod, err
+}
+
+// PatchPod applies patch to the named pod as a strategic merge patch. Most of
+// a pod's spec is immutable, so patches usually only touch metadata such as
+// labels and annotations. Rejected patches fail with a StatusError.
+func (c *Client) PatchPod(name string, patch Pod) (Pod, error) {
+	c.log("PatchPod", name, patch)
+	var retPod Pod
+	err := c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+		requestBody: &patch,
+	}, &retPod)
+	return retPod, err
+}
+
+// PatchPodStatus applies patch to the named pod's status subresource as a
+// strategic merge patch, for instance to set a readiness gate condition.
+func (c *Client) PatchPodStatus(name string, patch Pod) (Pod, error) {
+	c.log("PatchPodStatus", name, patch)
+	var retPod Pod
+	err := c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/status", c.namespace, name),
+		requestBody: &patch,
+	}, &retPod)
+	return retPod, err
+}
+
+// PatchPodRaw is like PatchPod, but sends patch exactly as given. Use it to
+// set fields to their zero values or, with nil values, to remove them.
+func (c *Client) PatchPodRaw(name string, patch map[string]interface{}) (Pod, error) {
+	c.log("PatchPodRaw", name, patch)
+	var retPod Pod
+	err := c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+		requestBody: patch,
+	}, &retPod)
+	return retPod, err
+}
+
+// AddPodLabel sets the label key to value on the named pod, leaving its
+// other labels alone.
+func (c *Client) AddPodLabel(name, key, value string) (Pod, error) {
+	return c.PatchPodRaw(name, metadataPatch("labels", key, value))
+}
+
+// AddPodAnnotation sets the annotation key to value on the named pod,
+// leaving its other annotations alone.
+func (c *Client) AddPodAnnotation(name, key, value string) (Pod, error) {
+	return c.PatchPodRaw(name, metadataPatch("annotations", key, value))
+}
+
+// metadataPatch builds a patch that sets a single label or annotation. Keys
+// such as prow.k8s.io/job need no escaping, since this isn't a JSON patch.
+func metadataPatch(field, key, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			field: map[string]string{key: value},
+		},
+	}
+}
+
+// DeletePodResult deletes the named pod and returns the api-server's view of
+// it. If the returned pod has a DeletionTimestamp, it is still terminating
+// gracefully. If the api-server instead responds with a Status because the
+// pod is already gone, the returned pod is empty.
+func (c *Client) DeletePodResult(name string) (Pod, error) {
+	c.log("DeletePodResult", name)
+	out, err := c.requestRetry(&request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, name),
+	})
+	if err != nil {
+		return Pod{}, err
+	}
+	var status Status
+	if err := json.Unmarshal(out, &status); err == nil && status.Kind == "Status" {
+		return Pod{}, nil
+	}
+	var retPod Pod
+	err = json.Unmarshal(out, &retPod)
+	return retPod, err
+}
+
 func (c *Client) GetJob(name string) (Job, error) {
 	c.log("GetJob", name)
 	var retJob Job
@@ -237,41 +1581,332 @@ func (c *Client) GetJob(name string) (Job, error) {
 	return retJob, err
 }
 
+// GetJobWithOptions is like GetJob, tuned by opts.
+func (c *Client) GetJobWithOptions(name string, opts GetOptions) (Job, error) {
+	c.log("GetJobWithOptions", name, opts)
+	var retJob Job
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", c.namespace, name),
+		query:  opts.query(),
+	}, &retJob)
+	return retJob, err
+}
+
+// GetJobPods returns the pods created by the named job, selected using the
+// job's selector or, if it has none, the job-name label. It returns an empty
+// slice if the job has not created any pods yet.
+func (c *Client) GetJobPods(jobName string) ([]Pod, error) {
+	c.log("GetJobPods", jobName)
+	job, err := c.GetJob(jobName)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{"job-name": jobName}
+	if job.Spec.Selector != nil && len(job.Spec.Selector.MatchLabels) > 0 {
+		labels = job.Spec.Selector.MatchLabels
+	}
+	pods, err := c.ListPods(labels)
+	if err != nil {
+		return nil, err
+	}
+	if pods == nil {
+		pods = []Pod{}
+	}
+	return pods, nil
+}
+
+// GetJobLogs returns the logs of every pod the named job has created, keyed
+// by pod name. Sort them by StartTime to order the job's attempts. A pod
+// whose log can no longer be found is recorded as Missing rather than
+// failing the whole call.
+func (c *Client) GetJobLogs(jobName string) (map[string]PodLog, error) {
+	c.log("GetJobLogs", jobName)
+	pods, err := c.GetJobPods(jobName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(pods))
+	for i, p := range pods {
+		names[i] = p.Metadata.Name
+	}
+	logs, errs := c.GetLogs(names)
+	jobLogs := make(map[string]PodLog, len(pods))
+	for i, p := range pods {
+		pl := PodLog{StartTime: p.Status.StartTime, Log: logs[p.Metadata.Name]}
+		if errs != nil && errs[i] != nil {
+			if !IsNotFound(errs[i]) {
+				return nil, fmt.Errorf("getting log of pod %s: %v", p.Metadata.Name, errs[i])
+			}
+			pl.Missing = true
+		}
+		jobLogs[p.Metadata.Name] = pl
+	}
+	return jobLogs, nil
+}
+
+// ListEventsForJob lists the events recorded against the named job, which
+// explain problems such as failing to create pods.
+func (c *Client) ListEventsForJob(jobName string) ([]Event, error) {
+	c.log("ListEventsForJob", jobName)
+	var el struct {
+		Items []Event `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/events", c.namespace),
+		query: map[string]string{
+			"fieldSelector": fieldsToSelector(map[string]string{
+				"involvedObject.kind": "Job",
+				"involvedObject.name": jobName,
+			}),
+		},
+	}, &el)
+	return el.Items, err
+}
+
+// RunJobAndGetPod creates j, waits for it to create a pod, and waits for that
+// pod to start running. It returns the created job and the pod. It gives up
+// with ctx.Err() once ctx is done.
+func (c *Client) RunJobAndGetPod(ctx context.Context, j Job) (Job, Pod, error) {
+	c.log("RunJobAndGetPod", j)
+	job, err := c.CreateJob(j)
+	if err != nil {
+		return job, Pod{}, err
+	}
+	var pods []Pod
+	for {
+		pods, err = c.GetJobPods(job.Metadata.Name)
+		if err != nil {
+			return job, Pod{}, err
+		}
+		if len(pods) > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return job, Pod{}, ctx.Err()
+		case <-c.clock().After(c.pollInterval()):
+		}
+	}
+	pod, err := c.WaitForPodPhase(ctx, pods[0].Metadata.Name, PodRunning)
+	return job, pod, err
+}
+
 func (c *Client) ListJobs(labels map[string]string) ([]Job, error) {
-	c.log("ListJobs", labels)
+	jl, err := c.ListJobsWithOptions(ListOptions{LabelSelector: labelsToSelector(labels)})
+	return jl.Items, err
+}
+
+// ListActiveJobs lists the jobs matching labels that are neither complete
+// nor failed. The filtering happens client-side after listing every match.
+func (c *Client) ListActiveJobs(labels map[string]string) ([]Job, error) {
+	return c.listJobsWhere(labels, func(j *Job) bool { return !j.Complete() && !j.Failed() })
+}
+
+// ListCompletedJobs lists the jobs matching labels that have finished,
+// either complete or failed. The filtering happens client-side after
+// listing every match.
+func (c *Client) ListCompletedJobs(labels map[string]string) ([]Job, error) {
+	return c.listJobsWhere(labels, func(j *Job) bool { return j.Complete() || j.Failed() })
+}
+
+// ListJobsSince lists the jobs matching labels that were created at or
+// after since. The filtering happens client-side after listing every match,
+// since the api-server can't select on creation time.
+func (c *Client) ListJobsSince(since time.Time, labels map[string]string) ([]Job, error) {
+	return c.listJobsWhere(labels, func(j *Job) bool {
+		return j.Metadata.CreationTimestamp != nil && !j.Metadata.CreationTimestamp.Before(since)
+	})
+}
+
+func (c *Client) listJobsWhere(labels map[string]string, keep func(*Job) bool) ([]Job, error) {
+	jobs, err := c.ListJobs(labels)
+	if err != nil {
+		return nil, err
+	}
+	var kept []Job
+	for i := range jobs {
+		if keep(&jobs[i]) {
+			kept = append(kept, jobs[i])
+		}
+	}
+	return kept, nil
+}
+
+// ListJobsWithOptions lists the jobs selected by opts.
+func (c *Client) ListJobsWithOptions(opts ListOptions) (JobList, error) {
+	c.log("ListJobsWithOptions", opts)
+	var jl JobList
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", c.namespace),
+		query:  opts.query(),
+	}, &jl)
+	return jl, err
+}
+
+// ListJobsAllNamespaces is like ListJobs but lists jobs in every namespace.
+// Each job's namespace is set in its metadata.
+func (c *Client) ListJobsAllNamespaces(labels map[string]string) ([]Job, error) {
+	c.log("ListJobsAllNamespaces", labels)
 	var jl struct {
 		Items []Job `json:"items"`
 	}
-	err := c.request(&request{
+	err := c.requestDecode(&request{
 		method: http.MethodGet,
-		path:   fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", c.namespace),
+		path:   "/apis/batch/v1/jobs",
 		query:  map[string]string{"labelSelector": labelsToSelector(labels)},
 	}, &jl)
 	return jl.Items, err
 }
 
 func (c *Client) CreatePod(p Pod) (Pod, error) {
-	c.log("CreatePod", p)
+	return c.CreatePodWithOptions(p, CreateOptions{})
+}
+
+// CreatePodGenerateName creates p with a name made up of prefix and a
+// random suffix chosen by the api-server, replacing any name p has, and
+// returns the name it was given.
+func (c *Client) CreatePodGenerateName(prefix string, p Pod) (string, error) {
+	p.Metadata.Name = ""
+	p.Metadata.GenerateName = prefix
+	created, err := c.CreatePod(p)
+	return created.Metadata.Name, err
+}
+
+// CreatePodWithOptions creates p as tuned by opts. An existing pod is only
+// returned in place of an AlreadyExistsError if p has a name to look it up
+// by, rather than a generateName.
+func (c *Client) CreatePodWithOptions(p Pod, opts CreateOptions) (Pod, error) {
+	c.log("CreatePodWithOptions", p, opts)
 	var retPod Pod
 	err := c.request(&request{
 		method:      http.MethodPost,
 		path:        fmt.Sprintf("/api/v1/namespaces/%s/pods", c.namespace),
 		requestBody: &p,
 	}, &retPod)
+	if opts.IgnoreAlreadyExists && IsAlreadyExists(err) && p.Metadata.Name != "" {
+		return c.GetPod(p.Metadata.Name)
+	}
 	return retPod, err
 }
 
+// CreatePods creates every pod in pods, a few at a time, without stopping at
+// the first failure. The results are indexed like pods: if errs[i] is nil
+// then created[i] is the created pod, and otherwise creating pods[i] failed.
+// errs is nil if every creation succeeded.
+func (c *Client) CreatePods(pods []Pod) (created []Pod, errs []error) {
+	created = make([]Pod, len(pods))
+	allErrs := make([]error, len(pods))
+	sem := make(chan struct{}, createPodsConcurrency)
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			created[i], allErrs[i] = c.CreatePod(pods[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range allErrs {
+		if err != nil {
+			return created, allErrs
+		}
+	}
+	return created, nil
+}
+
+// CreatePodOwnedBy creates p with owner as its controlling owner, so that the
+// pod is garbage-collected when the owner is deleted.
+func (c *Client) CreatePodOwnedBy(p Pod, owner OwnerReference) (Pod, error) {
+	p.Metadata.OwnerReferences = withController(p.Metadata.OwnerReferences, owner)
+	return c.CreatePod(p)
+}
+
 func (c *Client) CreateJob(j Job) (Job, error) {
-	c.log("CreateJob", j)
+	return c.CreateJobWithOptions(j, CreateOptions{})
+}
+
+// CreateJobWithOptions creates j as tuned by opts. An existing job is only
+// returned in place of an AlreadyExistsError if j has a name to look it up
+// by, rather than a generateName.
+func (c *Client) CreateJobWithOptions(j Job, opts CreateOptions) (Job, error) {
+	c.log("CreateJobWithOptions", j, opts)
 	var retJob Job
 	err := c.request(&request{
 		method:      http.MethodPost,
 		path:        fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", c.namespace),
 		requestBody: &j,
 	}, &retJob)
+	if opts.IgnoreAlreadyExists && IsAlreadyExists(err) && j.Metadata.Name != "" {
+		return c.GetJob(j.Metadata.Name)
+	}
+	return retJob, err
+}
+
+// ReplaceJob replaces the named job with j. If j carries a stale
+// resourceVersion the replace fails with a ConflictError. Most of a job's
+// spec is immutable once created, so attempts to change it fail with a 422
+// StatusError.
+func (c *Client) ReplaceJob(name string, j Job) (Job, error) {
+	c.log("ReplaceJob", name, j)
+	var retJob Job
+	err := c.request(&request{
+		method:      http.MethodPut,
+		path:        fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", c.namespace, name),
+		requestBody: &j,
+	}, &retJob)
 	return retJob, err
 }
 
+// CreateJobFromPod creates a job named name that runs p to completion once,
+// retrying it up to backoffLimit times. The job and its pods carry p's
+// labels, and the pods also carry p's annotations.
+func (c *Client) CreateJobFromPod(name string, p Pod, backoffLimit int32) (Job, error) {
+	one := 1
+	spec := p.Spec
+	spec.RestartPolicy = "Never"
+	return c.CreateJob(Job{
+		Metadata: ObjectMeta{
+			Name:   name,
+			Labels: p.Metadata.Labels,
+		},
+		Spec: JobSpec{
+			Completions:  &one,
+			Parallelism:  &one,
+			BackoffLimit: &backoffLimit,
+			Template: PodTemplateSpec{
+				Metadata: ObjectMeta{
+					Labels:      p.Metadata.Labels,
+					Annotations: p.Metadata.Annotations,
+				},
+				Spec: spec,
+			},
+		},
+	})
+}
+
+// CreateJobOwnedBy creates j with owner as its controlling owner, so that the
+// job is garbage-collected when the owner is deleted.
+func (c *Client) CreateJobOwnedBy(j Job, owner OwnerReference) (Job, error) {
+	j.Metadata.OwnerReferences = withController(j.Metadata.OwnerReferences, owner)
+	return c.CreateJob(j)
+}
+
+// withController returns a copy of refs with owner appended as the
+// controlling owner reference.
+func withController(refs []OwnerReference, owner OwnerReference) []OwnerReference {
+	t := true
+	owner.Controller = &t
+	owner.BlockOwnerDeletion = &t
+	return append(append([]OwnerReference(nil), refs...), owner)
+}
+
 func (c *Client) DeleteJob(name string) error {
 	c.log("DeleteJob", name)
 	return c.request(&request{
@@ -291,6 +1926,44 @@ func (c *Client) PatchJob(name string, job Job) (Job, error) {
 	return retJob, err
 }
 
+// PatchOrCreateJob patches the named job with job, or creates job if the
+// named job doesn't exist.
+func (c *Client) PatchOrCreateJob(name string, job Job) (Job, error) {
+	retJob, err := c.PatchJob(name, job)
+	if IsNotFound(err) {
+		if job.Metadata.Name == "" {
+			job.Metadata.Name = name
+		}
+		return c.CreateJob(job)
+	}
+	return retJob, err
+}
+
+// PatchJobRaw is like PatchJob, but sends patch exactly as given, rather
+// than a Job whose omitempty tags decide which zero values are sent.
+func (c *Client) PatchJobRaw(name string, patch map[string]interface{}) (Job, error) {
+	c.log("PatchJobRaw", name, patch)
+	var retJob Job
+	err := c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", c.namespace, name),
+		requestBody: patch,
+	}, &retJob)
+	return retJob, err
+}
+
+// AddJobLabel sets the label key to value on the named job, leaving its
+// other labels alone.
+func (c *Client) AddJobLabel(name, key, value string) (Job, error) {
+	return c.PatchJobRaw(name, metadataPatch("labels", key, value))
+}
+
+// AddJobAnnotation sets the annotation key to value on the named job,
+// leaving its other annotations alone.
+func (c *Client) AddJobAnnotation(name, key, value string) (Job, error) {
+	return c.PatchJobRaw(name, metadataPatch("annotations", key, value))
+}
+
 func (c *Client) PatchJobStatus(name string, job Job) (Job, error) {
 	c.log("PatchJobStatus", name, job)
 	var retJob Job
@@ -302,6 +1975,220 @@ func (c *Client) PatchJobStatus(name string, job Job) (Job, error) {
 	return retJob, err
 }
 
+// UpdateJobStatusWithRetry reads the named job, lets mutate change its
+// status, and writes the status back. The patch carries the resource
+// version that was read, so a concurrent update makes it fail with a
+// ConflictError, in which case the whole read-modify-write is retried.
+func (c *Client) UpdateJobStatusWithRetry(name string, mutate func(*Job)) (Job, error) {
+	c.log("UpdateJobStatusWithRetry", name)
+	var retJob Job
+	err := c.RetryOnConflict(func() error {
+		job, err := c.GetJob(name)
+		if err != nil {
+			return err
+		}
+		mutate(&job)
+		retJob, err = c.PatchJobStatus(name, job)
+		return err
+	})
+	return retJob, err
+}
+
+// SetJobCondition adds cond to the named job's status, replacing any existing
+// condition of the same type. Only the condition is sent, so other status
+// fields are left alone. A zero LastTransitionTime is set to now.
+func (c *Client) SetJobCondition(name string, cond JobCondition) (Job, error) {
+	c.log("SetJobCondition", name, cond)
+	if cond.LastTransitionTime.IsZero() {
+		cond.LastTransitionTime = c.clock().Now()
+	}
+	condition := map[string]interface{}{
+		"type":               cond.Type,
+		"status":             cond.Status,
+		"lastTransitionTime": cond.LastTransitionTime,
+	}
+	if !cond.LastProbeTime.IsZero() {
+		condition["lastProbeTime"] = cond.LastProbeTime
+	}
+	if cond.Reason != "" {
+		condition["reason"] = cond.Reason
+	}
+	if cond.Message != "" {
+		condition["message"] = cond.Message
+	}
+	// Conditions are merged by type in a strategic merge patch.
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []interface{}{condition},
+		},
+	}
+	var retJob Job
+	err := c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s/status", c.namespace, name),
+		requestBody: patch,
+	}, &retJob)
+	return retJob, err
+}
+
+func (c *Client) GetCronJob(name string) (CronJob, error) {
+	c.log("GetCronJob", name)
+	var retCronJob CronJob
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/batch/v1/namespaces/%s/cronjobs/%s", c.namespace, name),
+	}, &retCronJob)
+	return retCronJob, err
+}
+
+func (c *Client) ListCronJobs(labels map[string]string) ([]CronJob, error) {
+	c.log("ListCronJobs", labels)
+	var cl struct {
+		Items []CronJob `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/batch/v1/namespaces/%s/cronjobs", c.namespace),
+		query:  map[string]string{"labelSelector": labelsToSelector(labels)},
+	}, &cl)
+	return cl.Items, err
+}
+
+func (c *Client) CreateCronJob(cj CronJob) (CronJob, error) {
+	c.log("CreateCronJob", cj)
+	var retCronJob CronJob
+	err := c.request(&request{
+		method:      http.MethodPost,
+		path:        fmt.Sprintf("/apis/batch/v1/namespaces/%s/cronjobs", c.namespace),
+		requestBody: &cj,
+	}, &retCronJob)
+	return retCronJob, err
+}
+
+func (c *Client) DeleteCronJob(name string) error {
+	c.log("DeleteCronJob", name)
+	return c.request(&request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/apis/batch/v1/namespaces/%s/cronjobs/%s", c.namespace, name),
+	}, nil)
+}
+
+func (c *Client) GetDeployment(name string) (Deployment, error) {
+	c.log("GetDeployment", name)
+	var retDeployment Deployment
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s", c.namespace, name),
+	}, &retDeployment)
+	return retDeployment, err
+}
+
+// ScaleDeployment sets the number of replicas of the named deployment through
+// its scale subresource.
+func (c *Client) ScaleDeployment(name string, replicas int32) error {
+	c.log("ScaleDeployment", name, replicas)
+	return c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s/scale", c.namespace, name),
+		requestBody: &Scale{Spec: ScaleSpec{Replicas: replicas}},
+	}, nil)
+}
+
+func (c *Client) GetStatefulSet(name string) (StatefulSet, error) {
+	c.log("GetStatefulSet", name)
+	var retStatefulSet StatefulSet
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/apps/v1/namespaces/%s/statefulsets/%s", c.namespace, name),
+	}, &retStatefulSet)
+	return retStatefulSet, err
+}
+
+func (c *Client) ListStatefulSets(labels map[string]string) ([]StatefulSet, error) {
+	c.log("ListStatefulSets", labels)
+	var sl struct {
+		Items []StatefulSet `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/apps/v1/namespaces/%s/statefulsets", c.namespace),
+		query:  map[string]string{"labelSelector": labelsToSelector(labels)},
+	}, &sl)
+	return sl.Items, err
+}
+
+func (c *Client) GetDaemonSet(name string) (DaemonSet, error) {
+	c.log("GetDaemonSet", name)
+	var retDaemonSet DaemonSet
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/apps/v1/namespaces/%s/daemonsets/%s", c.namespace, name),
+	}, &retDaemonSet)
+	return retDaemonSet, err
+}
+
+func (c *Client) ListDaemonSets(labels map[string]string) ([]DaemonSet, error) {
+	c.log("ListDaemonSets", labels)
+	var dl struct {
+		Items []DaemonSet `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/apis/apps/v1/namespaces/%s/daemonsets", c.namespace),
+		query:  map[string]string{"labelSelector": labelsToSelector(labels)},
+	}, &dl)
+	return dl.Items, err
+}
+
+// ListSecrets lists secrets matching labels. Like ReplaceSecret, it never
+// logs secret data.
+func (c *Client) ListSecrets(labels map[string]string) ([]Secret, error) {
+	c.log("ListSecrets", labels)
+	var sl struct {
+		Items []Secret `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/secrets", c.namespace),
+		query:  map[string]string{"labelSelector": labelsToSelector(labels)},
+	}, &sl)
+	return sl.Items, err
+}
+
+func (c *Client) GetSecret(name string) (Secret, error) {
+	c.log("GetSecret", name)
+	var retSecret Secret
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", c.namespace, name),
+	}, &retSecret)
+	return retSecret, err
+}
+
+// WaitForSecret polls until the named secret exists, for instance once
+// another controller has created it, and returns it. If ctx is done first,
+// it returns ctx.Err(). Only the secret's name is logged.
+func (c *Client) WaitForSecret(ctx context.Context, name string) (Secret, error) {
+	c.log("WaitForSecret", name)
+	interval := c.pollInterval()
+	for {
+		var secret Secret
+		err := c.request(&request{
+			ctx:    ctx,
+			method: http.MethodGet,
+			path:   fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", c.namespace, name),
+		}, &secret)
+		if !IsNotFound(err) {
+			return secret, err
+		}
+		select {
+		case <-ctx.Done():
+			return Secret{}, ctx.Err()
+		case <-c.clock().After(interval):
+		}
+	}
+}
+
 func (c *Client) ReplaceSecret(name string, s Secret) error {
 	// Ommission of the secret from the logs is purposeful.
 	c.log("ReplaceSecret", name)
@@ -312,10 +2199,356 @@ func (c *Client) ReplaceSecret(name string, s Secret) error {
 	}, nil)
 }
 
+// PatchSecret applies patch to the named secret as a JSON merge patch, so
+// that keys missing from patch.Data are left alone. As with ReplaceSecret,
+// the data is never logged.
+func (c *Client) PatchSecret(name string, patch Secret) error {
+	c.log("PatchSecret", name)
+	return c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", c.namespace, name),
+		requestBody: &patch,
+		contentType: "application/merge-patch+json",
+	}, nil)
+}
+
+func (c *Client) GetConfigMap(name string) (ConfigMap, error) {
+	c.log("GetConfigMap", name)
+	var retConfigMap ConfigMap
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", c.namespace, name),
+	}, &retConfigMap)
+	return retConfigMap, err
+}
+
+// PatchConfigMap applies patch to the named config map as a JSON merge patch,
+// so that keys can be updated without resending the whole data map. For
+// instance, {"data": {"config.yaml": "..."}} replaces only that key.
+func (c *Client) PatchConfigMap(name string, patch []byte) (ConfigMap, error) {
+	c.log("PatchConfigMap", name, string(patch))
+	var retConfigMap ConfigMap
+	err := c.request(&request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", c.namespace, name),
+		requestBody: json.RawMessage(patch),
+		contentType: "application/merge-patch+json",
+	}, &retConfigMap)
+	return retConfigMap, err
+}
+
 func (c *Client) GetLog(pod string) ([]byte, error) {
 	c.log("GetLog", pod)
+	log, err := c.requestRetry(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", c.namespace, pod),
+	})
+	if err == nil {
+		c.debugf("Log of pod %s is %d bytes", pod, len(log))
+	}
+	return log, err
+}
+
+// GetLogs fetches the logs of every pod in pods, a few at a time, without
+// stopping at the first failure. The logs that were fetched are keyed by pod
+// name. errs is indexed like pods, and is nil if every fetch succeeded.
+func (c *Client) GetLogs(pods []string) (logs map[string][]byte, errs []error) {
+	logs = make(map[string][]byte, len(pods))
+	allErrs := make([]error, len(pods))
+	var lock sync.Mutex
+	sem := make(chan struct{}, getLogsConcurrency)
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			log, err := c.GetLog(pods[i])
+			if err != nil {
+				allErrs[i] = err
+				return
+			}
+			lock.Lock()
+			logs[pods[i]] = log
+			lock.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range allErrs {
+		if err != nil {
+			return logs, allErrs
+		}
+	}
+	return logs, nil
+}
+
+// GetLogSince returns the log of pod from since onwards. A zero since
+// returns the whole log.
+func (c *Client) GetLogSince(pod string, since time.Time) ([]byte, error) {
+	c.log("GetLogSince", pod, since)
+	query := map[string]string{}
+	if !since.IsZero() {
+		query["sinceTime"] = since.UTC().Format(time.RFC3339)
+	}
 	return c.requestRetry(&request{
 		method: http.MethodGet,
 		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", c.namespace, pod),
+		query:  query,
+	})
+}
+
+// GetAllContainerLogs returns the logs of every init container and container
+// in pod, keyed by container name. Containers that have not started yet have
+// an empty log.
+func (c *Client) GetAllContainerLogs(pod string) (map[string]string, error) {
+	c.log("GetAllContainerLogs", pod)
+	p, err := c.GetPod(pod)
+	if err != nil {
+		return nil, err
+	}
+	logs := map[string]string{}
+	for _, container := range append(p.Spec.InitContainers, p.Spec.Containers...) {
+		log, err := c.requestRetry(&request{
+			method: http.MethodGet,
+			path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", c.namespace, pod),
+			query:  map[string]string{"container": container.Name},
+		})
+		// The api-server answers 400 for containers that are still waiting
+		// to start.
+		if se, ok := err.(StatusError); ok && se.Code == http.StatusBadRequest {
+			log, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		logs[container.Name] = string(log)
+	}
+	return logs, nil
+}
+
+func (c *Client) GetNamespace(name string) (Namespace, error) {
+	c.log("GetNamespace", name)
+	var retNS Namespace
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s", name),
+	}, &retNS)
+	return retNS, err
+}
+
+func (c *Client) CreateNamespace(ns Namespace) (Namespace, error) {
+	c.log("CreateNamespace", ns)
+	var retNS Namespace
+	err := c.request(&request{
+		method:      http.MethodPost,
+		path:        "/api/v1/namespaces",
+		requestBody: &ns,
+	}, &retNS)
+	return retNS, err
+}
+
+func (c *Client) DeleteNamespace(name string) error {
+	c.log("DeleteNamespace", name)
+	return c.request(&request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s", name),
+	}, nil)
+}
+
+func (c *Client) GetResourceQuota(name string) (ResourceQuota, error) {
+	c.log("GetResourceQuota", name)
+	var retQuota ResourceQuota
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/resourcequotas/%s", c.namespace, name),
+	}, &retQuota)
+	return retQuota, err
+}
+
+// ListResourceQuotas lists the quotas in the client's namespace. Compare
+// each quota's Status.Used with its Status.Hard to see how close the
+// namespace is to its limits.
+func (c *Client) ListResourceQuotas() ([]ResourceQuota, error) {
+	c.log("ListResourceQuotas")
+	var ql struct {
+		Items []ResourceQuota `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/resourcequotas", c.namespace),
+	}, &ql)
+	return ql.Items, err
+}
+
+func (c *Client) GetPVC(name string) (PersistentVolumeClaim, error) {
+	c.log("GetPVC", name)
+	var retPVC PersistentVolumeClaim
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/persistentvolumeclaims/%s", c.namespace, name),
+	}, &retPVC)
+	return retPVC, err
+}
+
+func (c *Client) ListPVCs(labels map[string]string) ([]PersistentVolumeClaim, error) {
+	c.log("ListPVCs", labels)
+	var pl struct {
+		Items []PersistentVolumeClaim `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/persistentvolumeclaims", c.namespace),
+		query:  map[string]string{"labelSelector": labelsToSelector(labels)},
+	}, &pl)
+	return pl.Items, err
+}
+
+// CreatePVC creates pvc. The claim is usually Pending until a volume is
+// provisioned for it, which may not happen until a pod uses it.
+func (c *Client) CreatePVC(pvc PersistentVolumeClaim) (PersistentVolumeClaim, error) {
+	c.log("CreatePVC", pvc)
+	var retPVC PersistentVolumeClaim
+	err := c.request(&request{
+		method:      http.MethodPost,
+		path:        fmt.Sprintf("/api/v1/namespaces/%s/persistentvolumeclaims", c.namespace),
+		requestBody: &pvc,
+	}, &retPVC)
+	return retPVC, err
+}
+
+func (c *Client) DeletePVC(name string) error {
+	c.log("DeletePVC", name)
+	return c.request(&request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/persistentvolumeclaims/%s", c.namespace, name),
+	}, nil)
+}
+
+func (c *Client) GetLimitRange(name string) (LimitRange, error) {
+	c.log("GetLimitRange", name)
+	var retRange LimitRange
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/limitranges/%s", c.namespace, name),
+	}, &retRange)
+	return retRange, err
+}
+
+// ListLimitRanges lists the limit ranges in the client's namespace. The
+// "Container" limits' Default and DefaultRequest are what a container that
+// sets no resources of its own ends up with.
+func (c *Client) ListLimitRanges() ([]LimitRange, error) {
+	c.log("ListLimitRanges")
+	var ll struct {
+		Items []LimitRange `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/limitranges", c.namespace),
+	}, &ll)
+	return ll.Items, err
+}
+
+// CanI reports whether the client may perform verb on resource in its
+// namespace, such as ("create", "pods"). Resources outside the core group
+// are qualified with their group as in ("patch", "jobs.batch").
+func (c *Client) CanI(verb, resource string) (bool, error) {
+	c.log("CanI", verb, resource)
+	attrs := &ResourceAttributes{
+		Namespace: c.namespace,
+		Verb:      verb,
+		Resource:  resource,
+	}
+	if i := strings.Index(resource, "."); i != -1 {
+		attrs.Resource, attrs.Group = resource[:i], resource[i+1:]
+	}
+	var review SelfSubjectAccessReview
+	err := c.request(&request{
+		method: http.MethodPost,
+		path:   "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews",
+		requestBody: SelfSubjectAccessReview{
+			APIVersion: "authorization.k8s.io/v1",
+			Kind:       "SelfSubjectAccessReview",
+			Spec:       SelfSubjectAccessReviewSpec{ResourceAttributes: attrs},
+		},
+	}, &review)
+	return review.Status.Allowed, err
+}
+
+// FollowLog streams the log of pod as it is written. The returned reader
+// yields io.EOF once the pod exits and the api-server closes the stream. If
+// the connection drops before then, FollowLog reconnects and resumes from
+// the time of the drop, which may repeat or skip lines logged during that
+// second. Reads fail once ctx is done. The caller must close the reader.
+func (c *Client) FollowLog(ctx context.Context, pod string) (io.ReadCloser, error) {
+	c.log("FollowLog", pod)
+	f := &logFollower{client: c, ctx: ctx, pod: pod}
+	if err := f.connect(time.Time{}); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type logFollower struct {
+	client *Client
+	ctx    context.Context
+	pod    string
+
+	body io.ReadCloser
+	// reconnects counts reconnections since data was last read.
+	reconnects int
+}
+
+func (f *logFollower) connect(since time.Time) error {
+	query := map[string]string{"follow": "true"}
+	if !since.IsZero() {
+		query["sinceTime"] = since.UTC().Format(time.RFC3339)
+	}
+	body, err := f.client.requestRetryStream(&request{
+		ctx:    f.ctx,
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", f.client.namespace, f.pod),
+		query:  query,
+		stream: true,
 	})
+	if err != nil {
+		return err
+	}
+	f.body = body
+	return nil
+}
+
+func (f *logFollower) Read(p []byte) (int, error) {
+	for {
+		n, err := f.body.Read(p)
+		if n > 0 {
+			f.reconnects = 0
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			// Let the next read deal with the error.
+			return n, nil
+		}
+		if f.ctx.Err() != nil {
+			return 0, f.ctx.Err()
+		}
+		if !isRetryable(err) || f.reconnects >= maxRetries {
+			return 0, err
+		}
+		f.reconnects++
+		f.client.debugf("Log stream for pod %s dropped, reconnecting: %v", f.pod, err)
+		f.body.Close()
+		if err := f.connect(f.client.clock().Now()); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (f *logFollower) Close() error {
+	return f.body.Close()
 }
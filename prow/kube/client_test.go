@@ -17,10 +17,25 @@ limitations under the License.
 package kube
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func getClient(url string) *Client {
@@ -29,6 +44,7 @@ func getClient(url string) *Client {
 		client:    &http.Client{},
 		token:     "abcd",
 		namespace: "ns",
+		discovery: newDiscoveryCache(),
 	}
 }
 
@@ -175,6 +191,62 @@ func TestCreateJob(t *testing.T) {
 	}
 }
 
+func TestCreateJobFromPod(t *testing.T) {
+	pod := Pod{
+		Metadata: ObjectMeta{
+			Name:        "ignored",
+			Labels:      map[string]string{"app": "test"},
+			Annotations: map[string]string{"note": "x"},
+		},
+		Spec: PodSpec{
+			Containers:    []Container{{Name: "test", Image: "alpine"}},
+			RestartPolicy: "Always",
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/apis/batch/v1/namespaces/ns/jobs" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		var j Job
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if j.Metadata.Name != "jo" || !reflect.DeepEqual(j.Metadata.Labels, pod.Metadata.Labels) {
+			t.Errorf("Wrong job metadata: %+v", j.Metadata)
+		}
+		if j.Spec.Parallelism == nil || *j.Spec.Parallelism != 1 || j.Spec.Completions == nil || *j.Spec.Completions != 1 {
+			t.Errorf("Expected a single run, got %+v", j.Spec)
+		}
+		if j.Spec.BackoffLimit == nil || *j.Spec.BackoffLimit != 3 {
+			t.Errorf("Expected backoff limit 3, got %v", j.Spec.BackoffLimit)
+		}
+		expected := pod.Spec
+		expected.RestartPolicy = "Never"
+		if !reflect.DeepEqual(j.Spec.Template.Spec, expected) {
+			t.Errorf("Expected template spec %+v, got %+v", expected, j.Spec.Template.Spec)
+		}
+		if !reflect.DeepEqual(j.Spec.Template.Metadata.Labels, pod.Metadata.Labels) || !reflect.DeepEqual(j.Spec.Template.Metadata.Annotations, pod.Metadata.Annotations) {
+			t.Errorf("Wrong template metadata: %+v", j.Spec.Template.Metadata)
+		}
+		json.NewEncoder(w).Encode(j)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	jo, err := c.CreateJobFromPod("jo", pod, 3)
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if jo.Metadata.Name != "jo" {
+		t.Errorf("Wrong name: %s", jo.Metadata.Name)
+	}
+	if pod.Spec.RestartPolicy != "Always" {
+		t.Errorf("Expected the pod to be left alone, got restart policy %s", pod.Spec.RestartPolicy)
+	}
+}
+
 func TestDeleteJob(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
@@ -233,3 +305,3288 @@ func TestPatchJobStatus(t *testing.T) {
 		t.Errorf("Didn't expect error: %v", err)
 	}
 }
+
+func phaseServer(t *testing.T, phases ...PodPhase) *httptest.Server {
+	i := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/ns/pods/po" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		fmt.Fprintf(w, `{"status": {"phase": "%s"}}`, phases[i])
+		if i < len(phases)-1 {
+			i++
+		}
+	}))
+}
+
+func TestGetPodPhase(t *testing.T) {
+	ts := phaseServer(t, PodRunning)
+	defer ts.Close()
+	c := getClient(ts.URL)
+	phase, err := c.GetPodPhase("po")
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if phase != PodRunning {
+		t.Errorf("Wrong phase: %s", phase)
+	}
+}
+
+func TestWaitForPodPhase(t *testing.T) {
+	var testcases = []struct {
+		name   string
+		phases []PodPhase
+		want   PodPhase
+		// got is the phase of the returned pod, if it differs from want.
+		got       PodPhase
+		expectErr bool
+	}{
+		{
+			name:   "pending then running",
+			phases: []PodPhase{PodPending, PodPending, PodRunning},
+			want:   PodRunning,
+		},
+		{
+			name:   "running then succeeded",
+			phases: []PodPhase{PodPending, PodRunning, PodSucceeded},
+			want:   PodSucceeded,
+		},
+		{
+			name:   "succeeded while waiting for running",
+			phases: []PodPhase{PodPending, PodSucceeded},
+			want:   PodRunning,
+			got:    PodSucceeded,
+		},
+		{
+			name:      "failed while waiting for running",
+			phases:    []PodPhase{PodPending, PodFailed},
+			want:      PodRunning,
+			expectErr: true,
+		},
+		{
+			name:      "failed while waiting for succeeded",
+			phases:    []PodPhase{PodRunning, PodFailed},
+			want:      PodSucceeded,
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		ts := phaseServer(t, tc.phases...)
+		c := getClient(ts.URL)
+		c.PollInterval = time.Millisecond
+		pod, err := c.WaitForPodPhase(context.Background(), "po", tc.want)
+		ts.Close()
+		if tc.expectErr {
+			if _, ok := err.(UnexpectedPhaseError); !ok {
+				t.Errorf("%s: expected UnexpectedPhaseError, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		got := tc.got
+		if got == "" {
+			got = tc.want
+		}
+		if pod.Status.Phase != got {
+			t.Errorf("%s: wrong phase: %s", tc.name, pod.Status.Phase)
+		}
+	}
+}
+
+func TestWaitForPodPhaseTimeout(t *testing.T) {
+	ts := phaseServer(t, PodPending)
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.PollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitForPodPhase(ctx, "po", PodRunning); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items": [`)
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	c := getClient(ts.URL)
+	c.RequestTimeout = 10 * time.Millisecond
+	resp, err := c.doRequest(context.Background(), &request{
+		method: http.MethodGet,
+		path:   "/api/v1/namespaces/ns/pods",
+	})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err == nil {
+		t.Error("Expected hung body read to time out.")
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Expected gzip to be accepted, got %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		defer gw.Close()
+		fmt.Fprint(gw, `{"items": [{}, {}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	ps, err := c.ListPods(nil)
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(ps) != 2 {
+		t.Error("Expected two pods.")
+	}
+}
+
+func TestListPodsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no pods for you", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	if _, err := c.ListPods(nil); err == nil {
+		t.Error("Expected error.")
+	}
+}
+
+func TestListPodsAllNamespaces(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/pods" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("labelSelector") != "a = b" {
+			t.Errorf("Bad label selector: %s", r.URL.Query().Get("labelSelector"))
+		}
+		fmt.Fprint(w, `{"items": [{"metadata": {"namespace": "ns1"}}, {"metadata": {"namespace": "ns2"}}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	ps, err := c.ListPodsAllNamespaces(map[string]string{"a": "b"})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(ps) != 2 {
+		t.Fatal("Expected two pods.")
+	}
+	if ps[0].Metadata.Namespace != "ns1" || ps[1].Metadata.Namespace != "ns2" {
+		t.Errorf("Wrong namespaces: %s, %s", ps[0].Metadata.Namespace, ps[1].Metadata.Namespace)
+	}
+}
+
+func TestListJobsAllNamespaces(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/apis/batch/v1/jobs" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"items": [{"metadata": {"namespace": "ns1"}}, {"metadata": {"namespace": "ns2"}}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	js, err := c.ListJobsAllNamespaces(nil)
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(js) != 2 {
+		t.Fatal("Expected two jobs.")
+	}
+	if js[0].Metadata.Namespace != "ns1" || js[1].Metadata.Namespace != "ns2" {
+		t.Errorf("Wrong namespaces: %s, %s", js[0].Metadata.Namespace, js[1].Metadata.Namespace)
+	}
+}
+
+func TestNamespaceLifecycle(t *testing.T) {
+	namespaces := map[string]Namespace{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces":
+			var ns Namespace
+			if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+				t.Errorf("Bad request body: %v", err)
+			}
+			ns.Status.Phase = "Active"
+			namespaces[ns.Metadata.Name] = ns
+			json.NewEncoder(w).Encode(ns)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/scratch":
+			ns, ok := namespaces["scratch"]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(ns)
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/namespaces/scratch":
+			delete(namespaces, "scratch")
+		default:
+			t.Errorf("Bad request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	ns, err := c.CreateNamespace(Namespace{Metadata: ObjectMeta{Name: "scratch"}})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if ns.Metadata.Name != "scratch" || ns.Status.Phase != "Active" {
+		t.Errorf("Wrong namespace: %+v", ns)
+	}
+	if _, err := c.GetNamespace("scratch"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if err := c.DeleteNamespace("scratch"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if _, err := c.GetNamespace("scratch"); err == nil {
+		t.Error("Expected error getting deleted namespace.")
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	var testcases = []struct {
+		name         string
+		body         string
+		expectStatus bool
+	}{
+		{
+			name:         "status body",
+			body:         `{"kind": "Status", "status": "Failure", "message": "pods \"po\" is invalid", "reason": "Invalid", "code": 422}`,
+			expectStatus: true,
+		},
+		{
+			name: "plain body",
+			body: "invalid",
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, tc.body)
+		}))
+		c := getClient(ts.URL)
+		_, err := c.GetPod("po")
+		ts.Close()
+		se, ok := err.(StatusError)
+		if ok != tc.expectStatus {
+			t.Errorf("%s: expected StatusError %t, got %v", tc.name, tc.expectStatus, err)
+			continue
+		}
+		if !ok {
+			if err == nil || !strings.Contains(err.Error(), tc.body) {
+				t.Errorf("%s: expected raw body in error, got %v", tc.name, err)
+			}
+			continue
+		}
+		if se.Code != 422 || se.Reason != "Invalid" || se.Message != `pods "po" is invalid` {
+			t.Errorf("%s: wrong status error: %+v", tc.name, se)
+		}
+	}
+}
+
+func TestConflict(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "conflict")
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	err := c.ReplaceSecret("se", Secret{})
+	if !IsConflict(err) {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+	if IsConflict(fmt.Errorf("other")) {
+		t.Error("Didn't expect a plain error to be a conflict.")
+	}
+}
+
+func TestAlreadyExists(t *testing.T) {
+	var testcases = []struct {
+		name          string
+		body          string
+		alreadyExists bool
+	}{
+		{
+			name:          "create collision",
+			body:          `{"kind": "Status", "status": "Failure", "message": "pods \"po\" already exists", "reason": "AlreadyExists", "code": 409}`,
+			alreadyExists: true,
+		},
+		{
+			name: "update race",
+			body: `{"kind": "Status", "status": "Failure", "message": "Operation cannot be fulfilled on pods \"po\": the object has been modified", "reason": "Conflict", "code": 409}`,
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, tc.body)
+		}))
+		c := getClient(ts.URL)
+		_, err := c.CreatePod(Pod{Metadata: ObjectMeta{Name: "po"}})
+		ts.Close()
+		if IsAlreadyExists(err) != tc.alreadyExists {
+			t.Errorf("%s: expected IsAlreadyExists %t, got %v", tc.name, tc.alreadyExists, err)
+		}
+		if IsConflict(err) == tc.alreadyExists {
+			t.Errorf("%s: expected IsConflict %t, got %v", tc.name, !tc.alreadyExists, err)
+		}
+	}
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	var testcases = []struct {
+		name        string
+		errs        []error
+		expectCalls int
+		expectErr   bool
+	}{
+		{
+			name:        "success",
+			errs:        []error{nil},
+			expectCalls: 1,
+		},
+		{
+			name:        "conflict then success",
+			errs:        []error{ConflictError{}, ConflictError{}, nil},
+			expectCalls: 3,
+		},
+		{
+			name:        "other error is not retried",
+			errs:        []error{fmt.Errorf("other")},
+			expectCalls: 1,
+			expectErr:   true,
+		},
+		{
+			name:        "gives up after limit",
+			errs:        []error{ConflictError{}},
+			expectCalls: conflictRetries,
+			expectErr:   true,
+		},
+	}
+	for _, tc := range testcases {
+		calls := 0
+		clock := &fakeClock{}
+		c := getClient("")
+		c.Clock = clock
+		err := c.RetryOnConflict(func() error {
+			calls++
+			if calls > len(tc.errs) {
+				return tc.errs[len(tc.errs)-1]
+			}
+			return tc.errs[calls-1]
+		})
+		if calls != tc.expectCalls {
+			t.Errorf("%s: expected %d calls, got %d", tc.name, tc.expectCalls, calls)
+		}
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.expectErr, err)
+		}
+		if len(clock.waited) != tc.expectCalls-1 {
+			t.Errorf("%s: expected a backoff between each call, got %v", tc.name, clock.waited)
+		}
+	}
+}
+
+func TestCreateOwnedBy(t *testing.T) {
+	owner := OwnerReference{
+		APIVersion: "prow.k8s.io/v1",
+		Kind:       "ProwJob",
+		Name:       "pj",
+		UID:        "1234",
+	}
+	checkOwner := func(body ObjectMeta) {
+		if len(body.OwnerReferences) != 1 {
+			t.Errorf("Expected one owner reference, got %+v", body.OwnerReferences)
+			return
+		}
+		ref := body.OwnerReferences[0]
+		if ref.Name != "pj" || ref.UID != "1234" || ref.Kind != "ProwJob" {
+			t.Errorf("Wrong owner reference: %+v", ref)
+		}
+		if ref.Controller == nil || !*ref.Controller {
+			t.Error("Expected controller to be set.")
+		}
+		if ref.BlockOwnerDeletion == nil || !*ref.BlockOwnerDeletion {
+			t.Error("Expected blockOwnerDeletion to be set.")
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		var body struct {
+			Metadata ObjectMeta `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		checkOwner(body.Metadata)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	if _, err := c.CreatePodOwnedBy(Pod{}, owner); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if _, err := c.CreateJobOwnedBy(Job{}, owner); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+}
+
+func TestCronJobs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/batch/v1/namespaces/ns/cronjobs/cj":
+			fmt.Fprint(w, `{"metadata": {"name": "cj"}, "spec": {"schedule": "*/5 * * * *", "suspend": true}, "status": {"lastScheduleTime": "2017-06-01T12:00:00Z"}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/batch/v1/namespaces/ns/cronjobs":
+			fmt.Fprint(w, `{"items": [{}, {}]}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/apis/batch/v1/namespaces/ns/cronjobs":
+			var cj CronJob
+			if err := json.NewDecoder(r.Body).Decode(&cj); err != nil {
+				t.Errorf("Bad request body: %v", err)
+			}
+			if cj.Spec.Schedule != "@hourly" {
+				t.Errorf("Wrong schedule: %s", cj.Spec.Schedule)
+			}
+			json.NewEncoder(w).Encode(cj)
+		case r.Method == http.MethodDelete && r.URL.Path == "/apis/batch/v1/namespaces/ns/cronjobs/cj":
+		default:
+			t.Errorf("Bad request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	cj, err := c.GetCronJob("cj")
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if cj.Spec.Schedule != "*/5 * * * *" || cj.Spec.Suspend == nil || !*cj.Spec.Suspend {
+		t.Errorf("Wrong spec: %+v", cj.Spec)
+	}
+	if cj.Status.LastScheduleTime.IsZero() {
+		t.Error("Expected last schedule time to be set.")
+	}
+	cjs, err := c.ListCronJobs(nil)
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(cjs) != 2 {
+		t.Error("Expected two cron jobs.")
+	}
+	cj, err = c.CreateCronJob(CronJob{Spec: CronJobSpec{Schedule: "@hourly"}})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if cj.Spec.Schedule != "@hourly" {
+		t.Errorf("Wrong schedule: %s", cj.Spec.Schedule)
+	}
+	if err := c.DeleteCronJob("cj"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+}
+
+func TestGetDeployment(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/apis/apps/v1/namespaces/ns/deployments/deck" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"metadata": {"name": "deck"}, "spec": {"replicas": 3}, "status": {"readyReplicas": 2}}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	d, err := c.GetDeployment("deck")
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if d.Spec.Replicas == nil || *d.Spec.Replicas != 3 {
+		t.Errorf("Wrong replicas: %v", d.Spec.Replicas)
+	}
+	if d.Status.ReadyReplicas != 2 {
+		t.Errorf("Wrong ready replicas: %d", d.Status.ReadyReplicas)
+	}
+}
+
+func TestStatefulSetsAndDaemonSets(t *testing.T) {
+	ss := `{"metadata": {"name": "cache"}, "spec": {"replicas": 3}, "status": {"replicas": 3, "readyReplicas": 2}}`
+	ds := `{"metadata": {"name": "agent"}, "status": {"desiredNumberScheduled": 5, "numberReady": 4}}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/apis/apps/v1/namespaces/ns/statefulsets/cache":
+			fmt.Fprint(w, ss)
+		case "/apis/apps/v1/namespaces/ns/statefulsets":
+			fmt.Fprintf(w, `{"items": [%s]}`, ss)
+		case "/apis/apps/v1/namespaces/ns/daemonsets/agent":
+			fmt.Fprint(w, ds)
+		case "/apis/apps/v1/namespaces/ns/daemonsets":
+			fmt.Fprintf(w, `{"items": [%s]}`, ds)
+		default:
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	s, err := c.GetStatefulSet("cache")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if s.Spec.Replicas == nil || *s.Spec.Replicas != 3 || s.Status.ReadyReplicas != 2 {
+		t.Errorf("Wrong stateful set: %+v", s)
+	}
+	sl, err := c.ListStatefulSets(map[string]string{"app": "cache"})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(sl) != 1 || sl[0].Metadata.Name != "cache" {
+		t.Errorf("Wrong stateful sets: %+v", sl)
+	}
+	d, err := c.GetDaemonSet("agent")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if d.Status.DesiredNumberScheduled != 5 || d.Status.NumberReady != 4 {
+		t.Errorf("Wrong daemon set: %+v", d)
+	}
+	dl, err := c.ListDaemonSets(nil)
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(dl) != 1 || dl[0].Metadata.Name != "agent" {
+		t.Errorf("Wrong daemon sets: %+v", dl)
+	}
+}
+
+func TestScaleDeployment(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/apis/apps/v1/namespaces/ns/deployments/deck/scale" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		var s Scale
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if s.Spec.Replicas != 5 {
+			t.Errorf("Wrong replicas: %d", s.Spec.Replicas)
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	if err := c.ScaleDeployment("deck", 5); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+}
+
+func TestRequestRetryCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// Closing the server makes every attempt fail with a transport error.
+	ts.Close()
+	c := getClient(ts.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err := c.requestRetry(&request{
+		ctx:    ctx,
+		method: http.MethodGet,
+		path:   "/api/v1/namespaces/ns/pods/po",
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > retryDelay {
+		t.Errorf("Expected cancellation to interrupt the backoff, took %v", elapsed)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+	_, refusedErr := http.Get(ts.URL)
+	_, parseErr := http.NewRequest(http.MethodGet, "http://[::1", nil)
+	_, marshalErr := json.Marshal(func() {})
+	var testcases = []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{
+			name:      "connection refused",
+			err:       refusedErr,
+			retryable: true,
+		},
+		{
+			name:      "request timeout",
+			err:       &url.Error{Op: "Get", URL: ts.URL, Err: context.DeadlineExceeded},
+			retryable: true,
+		},
+		{
+			name:      "unexpected EOF",
+			err:       &url.Error{Op: "Get", URL: ts.URL, Err: io.ErrUnexpectedEOF},
+			retryable: true,
+		},
+		{
+			name: "context cancelled",
+			err:  &url.Error{Op: "Get", URL: ts.URL, Err: context.Canceled},
+		},
+		{
+			name: "unknown certificate authority",
+			err:  &url.Error{Op: "Get", URL: ts.URL, Err: x509.UnknownAuthorityError{}},
+		},
+		{
+			name: "wrong hostname",
+			err:  &url.Error{Op: "Get", URL: ts.URL, Err: x509.HostnameError{}},
+		},
+		{
+			name: "malformed URL",
+			err:  parseErr,
+		},
+		{
+			name: "unmarshalable body",
+			err:  marshalErr,
+		},
+	}
+	for _, tc := range testcases {
+		if tc.err == nil {
+			t.Errorf("%s: test case has no error", tc.name)
+			continue
+		}
+		if isRetryable(tc.err) != tc.retryable {
+			t.Errorf("%s: expected retryable %t for %v", tc.name, tc.retryable, tc.err)
+		}
+	}
+}
+
+func TestRawResource(t *testing.T) {
+	const collection = "/apis/prow.k8s.io/v1/namespaces/default/prowjobs"
+	type prowJob struct {
+		Metadata ObjectMeta `json:"metadata,omitempty"`
+		Spec     struct {
+			Job string `json:"job,omitempty"`
+		} `json:"spec,omitempty"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == collection+"/pj":
+			if r.URL.Query().Get("a") != "b" {
+				t.Errorf("Bad query: %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `{"metadata": {"name": "pj"}, "spec": {"job": "unit"}}`)
+		case r.Method == http.MethodPost && r.URL.Path == collection:
+			io.Copy(w, r.Body)
+		case r.Method == http.MethodPatch && r.URL.Path == collection+"/pj":
+			if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+				t.Errorf("Bad Content-Type: %s", r.Header.Get("Content-Type"))
+			}
+			io.Copy(w, r.Body)
+		case r.Method == http.MethodDelete && r.URL.Path == collection+"/pj":
+		default:
+			t.Errorf("Bad request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	var pj prowJob
+	if err := c.GetRawResource(collection+"/pj", map[string]string{"a": "b"}, &pj); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if pj.Metadata.Name != "pj" || pj.Spec.Job != "unit" {
+		t.Errorf("Wrong prow job: %+v", pj)
+	}
+	var created prowJob
+	if err := c.CreateRawResource(collection, pj, &created); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if created.Spec.Job != "unit" {
+		t.Errorf("Wrong created prow job: %+v", created)
+	}
+	if err := c.PatchRawResource(collection+"/pj", map[string]string{}, nil); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if err := c.DeleteRawResource(collection + "/pj"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+}
+
+func TestReplacePod(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/pods/po" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		var p Pod
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if p.Spec.RestartPolicy != "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "message": "Pod \"po\" is invalid: spec: Forbidden: pod updates may not change fields other than ...", "reason": "Invalid", "code": 422}`)
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	po, err := c.ReplacePod("po", Pod{Metadata: ObjectMeta{Name: "po", Labels: map[string]string{"a": "b"}}})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if po.Metadata.Labels["a"] != "b" {
+		t.Errorf("Wrong labels: %v", po.Metadata.Labels)
+	}
+	_, err = c.ReplacePod("po", Pod{Spec: PodSpec{RestartPolicy: "Always"}})
+	if se, ok := err.(StatusError); !ok || se.Code != 422 || se.Reason != "Invalid" {
+		t.Errorf("Expected a 422 StatusError, got %v", err)
+	}
+}
+
+func TestReplaceJob(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/apis/batch/v1/namespaces/ns/jobs/jo" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		var j Job
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		switch {
+		case j.Metadata.ResourceVersion != "2":
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "message": "the object has been modified", "reason": "Conflict", "code": 409}`)
+		case len(j.Spec.Template.Spec.Containers) != 0:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "message": "Job.batch \"jo\" is invalid: spec.template: Invalid value: ...: field is immutable", "reason": "Invalid", "code": 422}`)
+		default:
+			j.Metadata.ResourceVersion = "3"
+			json.NewEncoder(w).Encode(j)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	jo, err := c.ReplaceJob("jo", Job{Metadata: ObjectMeta{Name: "jo", ResourceVersion: "2", Labels: map[string]string{"a": "b"}}})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if jo.Metadata.Labels["a"] != "b" || jo.Metadata.ResourceVersion != "3" {
+		t.Errorf("Wrong job: %+v", jo.Metadata)
+	}
+	_, err = c.ReplaceJob("jo", Job{Metadata: ObjectMeta{Name: "jo", ResourceVersion: "1"}})
+	if !IsConflict(err) {
+		t.Errorf("Expected a ConflictError, got %v", err)
+	}
+	_, err = c.ReplaceJob("jo", Job{
+		Metadata: ObjectMeta{Name: "jo", ResourceVersion: "2"},
+		Spec:     JobSpec{Template: PodTemplateSpec{Spec: PodSpec{Containers: []Container{{Image: "new"}}}}},
+	})
+	if se, ok := err.(StatusError); !ok || se.Code != 422 || !strings.Contains(se.Message, "immutable") {
+		t.Errorf("Expected a 422 StatusError about an immutable field, got %v", err)
+	}
+}
+
+func TestListPodsByField(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/pods" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("fieldSelector") != "spec.nodeName=n1,status.phase=Succeeded" {
+			t.Errorf("Bad field selector: %s", r.URL.Query().Get("fieldSelector"))
+		}
+		fmt.Fprint(w, `{"items": [{}, {}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	ps, err := c.ListPodsByField(nil, map[string]string{"status.phase": "Succeeded", "spec.nodeName": "n1"})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(ps) != 2 {
+		t.Error("Expected two pods.")
+	}
+}
+
+func TestDeletePodsByField(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/pods" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("fieldSelector") != "status.phase=Succeeded" {
+			t.Errorf("Bad field selector: %s", r.URL.Query().Get("fieldSelector"))
+		}
+		if r.URL.Query().Get("labelSelector") != "created-by-prow = true" {
+			t.Errorf("Bad label selector: %s", r.URL.Query().Get("labelSelector"))
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	err := c.DeletePodsByField(map[string]string{"created-by-prow": "true"}, map[string]string{"status.phase": string(PodSucceeded)})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if err := c.DeletePodsByField(nil, nil); err == nil {
+		t.Error("Expected error deleting with no selectors.")
+	}
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(s string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(s, v...))
+}
+
+func TestRequestIDReusedAcrossRetries(t *testing.T) {
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get(requestIDHeader))
+		if len(ids) == 1 {
+			// Drop the connection so that the client sees a transport error.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Couldn't hijack: %v", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.initialBackoff = time.Millisecond
+	logger := &recordingLogger{}
+	c.Logger = logger
+	if _, err := c.GetPod("po"); err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("Expected two attempts, got %d", len(ids))
+	}
+	if ids[0] == "" || ids[0] != ids[1] {
+		t.Errorf("Expected the same request ID on each attempt, got %q", ids)
+	}
+	found := false
+	for _, m := range logger.messages {
+		if strings.Contains(m, ids[0]) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected request ID %s to be logged, got %q", ids[0], logger.messages)
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(requestIDHeader); id != "from-deck" {
+			t.Errorf("Wrong request ID: %q", id)
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	_, err := c.requestRetry(&request{
+		ctx:    WithRequestID(context.Background(), "from-deck"),
+		method: http.MethodGet,
+		path:   "/api/v1/namespaces/ns/pods/po",
+	})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+}
+
+func TestGetJobPods(t *testing.T) {
+	var testcases = []struct {
+		name          string
+		job           string
+		pods          string
+		expectedLabel string
+		expectedPods  int
+	}{
+		{
+			name:          "job with selector",
+			job:           `{"metadata": {"name": "jo"}, "spec": {"selector": {"matchLabels": {"controller-uid": "1234"}}}}`,
+			pods:          `{"items": [{}, {}]}`,
+			expectedLabel: "controller-uid = 1234",
+			expectedPods:  2,
+		},
+		{
+			name:          "job without selector",
+			job:           `{"metadata": {"name": "jo"}}`,
+			pods:          `{"items": [{}, {}]}`,
+			expectedLabel: "job-name = jo",
+			expectedPods:  2,
+		},
+		{
+			name:          "no pods yet",
+			job:           `{"metadata": {"name": "jo"}}`,
+			pods:          `{"items": []}`,
+			expectedLabel: "job-name = jo",
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/apis/batch/v1/namespaces/ns/jobs/jo":
+				fmt.Fprint(w, tc.job)
+			case "/api/v1/namespaces/ns/pods":
+				if sel := r.URL.Query().Get("labelSelector"); sel != tc.expectedLabel {
+					t.Errorf("%s: bad label selector: %s", tc.name, sel)
+				}
+				fmt.Fprint(w, tc.pods)
+			default:
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+		}))
+		c := getClient(ts.URL)
+		pods, err := c.GetJobPods("jo")
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		if pods == nil || len(pods) != tc.expectedPods {
+			t.Errorf("%s: expected %d pods, got %v", tc.name, tc.expectedPods, pods)
+		}
+	}
+}
+
+func TestGetJobLogs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apis/batch/v1/namespaces/ns/jobs/jo":
+			fmt.Fprint(w, `{"metadata": {"name": "jo"}, "spec": {"selector": {"matchLabels": {"controller-uid": "1234"}}}}`)
+		case "/api/v1/namespaces/ns/pods":
+			fmt.Fprint(w, `{"items": [
+				{"metadata": {"name": "jo-second"}, "status": {"startTime": "2017-06-01T12:10:00Z"}},
+				{"metadata": {"name": "jo-first"}, "status": {"startTime": "2017-06-01T12:00:00Z"}},
+				{"metadata": {"name": "jo-gone"}, "status": {"startTime": "2017-06-01T11:50:00Z"}}
+			]}`)
+		case "/api/v1/namespaces/ns/pods/jo-first/log":
+			fmt.Fprint(w, "first attempt")
+		case "/api/v1/namespaces/ns/pods/jo-second/log":
+			fmt.Fprint(w, "second attempt")
+		case "/api/v1/namespaces/ns/pods/jo-gone/log":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind": "Status", "code": 404, "reason": "NotFound"}`)
+		default:
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	logs, err := c.GetJobLogs("jo")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	expected := map[string]PodLog{
+		"jo-first":  {StartTime: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC), Log: []byte("first attempt")},
+		"jo-second": {StartTime: time.Date(2017, 6, 1, 12, 10, 0, 0, time.UTC), Log: []byte("second attempt")},
+		"jo-gone":   {StartTime: time.Date(2017, 6, 1, 11, 50, 0, 0, time.UTC), Missing: true},
+	}
+	if !reflect.DeepEqual(logs, expected) {
+		t.Errorf("Expected logs %+v, got %+v", expected, logs)
+	}
+}
+
+func TestRetryOn5xx(t *testing.T) {
+	var testcases = []struct {
+		name          string
+		retryOn5xx    bool
+		statuses      []int
+		expectErr     bool
+		expectedCalls int
+	}{
+		{
+			name:          "503 then 200 with retries",
+			retryOn5xx:    true,
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusOK},
+			expectedCalls: 2,
+		},
+		{
+			name:          "503 without retries",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusOK},
+			expectErr:     true,
+			expectedCalls: 1,
+		},
+		{
+			name:          "404 is never retried",
+			retryOn5xx:    true,
+			statuses:      []int{http.StatusNotFound, http.StatusOK},
+			expectErr:     true,
+			expectedCalls: 1,
+		},
+		{
+			name:          "persistent 504 gives up",
+			retryOn5xx:    true,
+			statuses:      []int{http.StatusGatewayTimeout},
+			expectErr:     true,
+			expectedCalls: maxRetries,
+		},
+	}
+	for _, tc := range testcases {
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := tc.statuses[len(tc.statuses)-1]
+			if calls < len(tc.statuses) {
+				status = tc.statuses[calls]
+			}
+			calls++
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{}`)
+		}))
+		c := getClient(ts.URL)
+		c.initialBackoff = time.Microsecond
+		c.RetryOn5xx = tc.retryOn5xx
+		_, err := c.GetPod("po")
+		ts.Close()
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.expectErr, err)
+		}
+		if calls != tc.expectedCalls {
+			t.Errorf("%s: expected %d calls, got %d", tc.name, tc.expectedCalls, calls)
+		}
+	}
+}
+
+func TestTruncatedResponse(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		truncate func(w http.ResponseWriter)
+	}{
+		{
+			name: "connection reset mid-list",
+			truncate: func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"items": [{"metadata": {"name": "a"}}, {"metad`)
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			},
+		},
+		{
+			name: "incomplete JSON",
+			truncate: func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"items": [{"metadata": {"name": "a"}}, {"metad`)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls%2 == 1 {
+				tc.truncate(w)
+				return
+			}
+			if r.URL.Path == "/api/v1/namespaces/ns/pods" {
+				fmt.Fprint(w, `{"items": [{"metadata": {"name": "a"}}, {"metadata": {"name": "b"}}]}`)
+			} else {
+				fmt.Fprint(w, `{"metadata": {"name": "po"}}`)
+			}
+		}))
+		ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+		c := getClient(ts.URL)
+		c.initialBackoff = time.Microsecond
+		pods, err := c.ListPods(nil)
+		if err != nil {
+			t.Errorf("%s: didn't expect ListPods error: %v", tc.name, err)
+		} else if len(pods) != 2 {
+			t.Errorf("%s: expected 2 pods, got %+v", tc.name, pods)
+		}
+		pod, err := c.GetPod("po")
+		if err != nil {
+			t.Errorf("%s: didn't expect GetPod error: %v", tc.name, err)
+		} else if pod.Metadata.Name != "po" {
+			t.Errorf("%s: wrong pod: %+v", tc.name, pod)
+		}
+		if calls != 4 {
+			t.Errorf("%s: expected each request to be retried once, got %d calls", tc.name, calls)
+		}
+		if _, err := c.WithoutRetries().ListPods(nil); !isTruncated(err) {
+			t.Errorf("%s: expected a truncated response without retries, got %v", tc.name, err)
+		}
+		ts.Close()
+	}
+}
+
+func TestTruncatedResponseRetriesShareBudget(t *testing.T) {
+	var mu sync.Mutex
+	ids := map[string]bool{}
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		ids[r.Header.Get(requestIDHeader)] = true
+		mu.Unlock()
+		fmt.Fprint(w, `{"items": [{"metadata": {"name": "a"}}, {"metad`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.Clock = &fakeClock{now: time.Now()}
+	exhausted := 0
+	c.RetriesExhausted = func(method, path string) { exhausted++ }
+	if _, err := c.ListPods(nil); !isTruncated(err) {
+		t.Errorf("expected a truncated response, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != maxRetries {
+		t.Errorf("expected %d attempts in total, got %d", maxRetries, calls)
+	}
+	if len(ids) != 1 {
+		t.Errorf("expected every attempt to share one request ID, got %v", ids)
+	}
+	if exhausted != 1 {
+		t.Errorf("expected RetriesExhausted to be called once, got %d", exhausted)
+	}
+	calls, exhausted = 0, 0
+	mu.Unlock()
+	_, err := c.CreatePod(Pod{Metadata: ObjectMeta{Name: "po"}})
+	mu.Lock()
+	if !isTruncated(err) {
+		t.Errorf("expected a truncated response, got %v", err)
+	}
+	if calls != 1 || exhausted != 0 {
+		t.Errorf("expected a POST to be tried once without exhausting retries, got %d calls and %d exhausted", calls, exhausted)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		statuses []int
+		expected int
+	}{
+		{
+			name:     "succeeds after a retry",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusOK},
+		},
+		{
+			name:     "not retryable",
+			statuses: []int{http.StatusNotFound},
+		},
+		{
+			name:     "persistent 503",
+			statuses: []int{http.StatusServiceUnavailable},
+			expected: 1,
+		},
+	}
+	for _, tc := range testcases {
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := tc.statuses[len(tc.statuses)-1]
+			if calls < len(tc.statuses) {
+				status = tc.statuses[calls]
+			}
+			calls++
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{}`)
+		}))
+		c := getClient(ts.URL)
+		c.initialBackoff = time.Microsecond
+		c.RetryOn5xx = true
+		var exhausted []string
+		c.RetriesExhausted = func(method, path string) {
+			exhausted = append(exhausted, method+" "+path)
+		}
+		c.GetPod("po")
+		ts.Close()
+		if len(exhausted) != tc.expected {
+			t.Errorf("%s: expected %d exhausted calls, got %v", tc.name, tc.expected, exhausted)
+		}
+		for _, e := range exhausted {
+			if e != "GET /api/v1/namespaces/ns/pods/po" {
+				t.Errorf("%s: wrong exhausted call: %s", tc.name, e)
+			}
+		}
+	}
+
+	// Transport failures count too, once per call.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+	c := getClient(ts.URL)
+	c.initialBackoff = time.Microsecond
+	exhausted := 0
+	c.RetriesExhausted = func(method, path string) { exhausted++ }
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetPod("po"); err == nil {
+			t.Error("Expected an error from a closed server.")
+		}
+	}
+	if exhausted != 2 {
+		t.Errorf("Expected 2 exhausted calls, got %d", exhausted)
+	}
+}
+
+// fakeClock advances instantly by however long it is asked to wait.
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	waited []time.Duration
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.now
+}
+
+// step moves the clock forward by d without recording a wait.
+func (f *fakeClock) step(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.Lock()
+	defer f.Unlock()
+	f.now = f.now.Add(d)
+	f.waited = append(f.waited, d)
+	ch := make(chan time.Time, 1)
+	ch <- f.now
+	return ch
+}
+
+func TestClock(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		maxElapsedTime time.Duration
+		expected       []time.Duration
+	}{
+		{
+			name: "every retry",
+			expected: []time.Duration{
+				2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+				32 * time.Second, 64 * time.Second, 128 * time.Second,
+			},
+		},
+		{
+			name:           "until MaxElapsedTime",
+			maxElapsedTime: 10 * time.Second,
+			expected:       []time.Duration{2 * time.Second, 4 * time.Second},
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		c := getClient(ts.URL)
+		clock := &fakeClock{now: time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)}
+		c.Clock = clock
+		c.RetryOn5xx = true
+		c.MaxElapsedTime = tc.maxElapsedTime
+		start := time.Now()
+		if _, err := c.GetPod("po"); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+		ts.Close()
+		if !reflect.DeepEqual(clock.waited, tc.expected) {
+			t.Errorf("%s: expected backoff %v, got %v", tc.name, tc.expected, clock.waited)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: expected no real waiting, took %v", tc.name, elapsed)
+		}
+	}
+}
+
+func TestClockPolling(t *testing.T) {
+	ts := phaseServer(t, PodPending, PodPending, PodRunning)
+	defer ts.Close()
+	c := getClient(ts.URL)
+	clock := &fakeClock{}
+	c.Clock = clock
+	if _, err := c.WaitForPodPhase(context.Background(), "po", PodRunning); err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if expected := []time.Duration{defaultPollInterval, defaultPollInterval}; !reflect.DeepEqual(clock.waited, expected) {
+		t.Errorf("Expected to poll every %v, waited %v", defaultPollInterval, clock.waited)
+	}
+}
+
+func TestMaxElapsedTime(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.initialBackoff = time.Millisecond
+	c.RetryOn5xx = true
+	c.MaxElapsedTime = 50 * time.Millisecond
+	_, err := c.GetPod("po")
+	rte, ok := err.(RetryTimeoutError)
+	if !ok {
+		t.Fatalf("Expected RetryTimeoutError, got %v", err)
+	}
+	if calls >= maxRetries || rte.Attempts != calls {
+		t.Errorf("Expected to give up before running out of retries, got %d calls and %d attempts", calls, rte.Attempts)
+	}
+	if rte.Err == nil || !strings.Contains(rte.Err.Error(), "503") {
+		t.Errorf("Expected the last failure to be a 503, got %v", rte.Err)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	body := `{"items": [{}, {}]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.MaxResponseBytes = int64(len(body))
+	if _, err := c.ListPods(nil); err != nil {
+		t.Errorf("Didn't expect error at the limit: %v", err)
+	}
+	c.MaxResponseBytes = int64(len(body) - 1)
+	if _, err := c.ListPods(nil); err != (ResponseTooLargeError{Limit: c.MaxResponseBytes}) {
+		t.Errorf("Expected ResponseTooLargeError from ListPods, got %v", err)
+	}
+	if _, err := c.GetPod("po"); err != (ResponseTooLargeError{Limit: c.MaxResponseBytes}) {
+		t.Errorf("Expected ResponseTooLargeError from GetPod, got %v", err)
+	}
+}
+
+type recordingDebugLogger struct {
+	recordingLogger
+	debug []string
+}
+
+func (l *recordingDebugLogger) Debugf(s string, v ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(s, v...))
+}
+
+func TestRetryLogging(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.initialBackoff = time.Microsecond
+	c.RetryOn5xx = true
+	logger := &recordingDebugLogger{}
+	c.Logger = logger
+	if _, err := c.GetPod("po"); err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(logger.debug) != 2 {
+		t.Fatalf("Expected two retry messages, got %q", logger.debug)
+	}
+	for i, backoff := range []string{"1µs", "2µs"} {
+		m := logger.debug[i]
+		if !strings.Contains(m, fmt.Sprintf("attempt %d/%d", i+1, maxRetries)) || !strings.Contains(m, backoff) || !strings.Contains(m, "503") {
+			t.Errorf("Retry message %d lacks attempt, backoff, or status: %q", i, m)
+		}
+	}
+	for _, m := range logger.messages {
+		if strings.Contains(m, "attempt") {
+			t.Errorf("Expected retry messages only at debug level, got %q", m)
+		}
+	}
+}
+
+func TestGetConfigMap(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/configmaps/config" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"metadata": {"name": "config"}, "data": {"config.yaml": "a: b"}}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	cm, err := c.GetConfigMap("config")
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if cm.Data["config.yaml"] != "a: b" {
+		t.Errorf("Wrong data: %v", cm.Data)
+	}
+}
+
+func TestPatchConfigMap(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/configmaps/config" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			t.Errorf("Bad Content-Type: %s", r.Header.Get("Content-Type"))
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Couldn't read body: %v", err)
+		}
+		if string(b) != `{"data":{"plugins.yaml":"new"}}` {
+			t.Errorf("Bad request body: %s", string(b))
+		}
+		fmt.Fprint(w, `{"metadata": {"name": "config"}, "data": {"config.yaml": "old", "plugins.yaml": "new"}}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	cm, err := c.PatchConfigMap("config", []byte(`{"data":{"plugins.yaml":"new"}}`))
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(cm.Data) != 2 {
+		t.Errorf("Wrong data: %v", cm.Data)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/ns/pods/po/log" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("follow") != "true" {
+			t.Errorf("Expected follow=true, got %s", r.URL.RawQuery)
+		}
+		for _, chunk := range []string{"one\n", "two\n", "three\n"} {
+			fmt.Fprint(w, chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.MaxResponseBytes = 1
+	rc, err := c.FollowLog(context.Background(), "po")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Errorf("Expected a clean EOF, got %v", err)
+	}
+	if string(b) != "one\ntwo\nthree\n" {
+		t.Errorf("Wrong log: %q", string(b))
+	}
+}
+
+func TestFollowLogReconnect(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			if r.URL.Query().Get("sinceTime") != "" {
+				t.Errorf("Didn't expect sinceTime on first request: %s", r.URL.RawQuery)
+			}
+			// Send one chunk, then drop the connection mid-stream.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Couldn't hijack: %v", err)
+				return
+			}
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\none\n\r\n")
+			conn.Close()
+			return
+		}
+		if r.URL.Query().Get("sinceTime") == "" {
+			t.Errorf("Expected sinceTime on reconnect: %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, "two\n")
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	rc, err := c.FollowLog(context.Background(), "po")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Errorf("Expected a clean EOF, got %v", err)
+	}
+	if string(b) != "one\ntwo\n" {
+		t.Errorf("Wrong log: %q", string(b))
+	}
+}
+
+func TestFollowLogCancel(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "one\n")
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	c := getClient(ts.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := c.FollowLog(ctx, "po")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	defer rc.Close()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := ioutil.ReadAll(rc); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestListSecrets(t *testing.T) {
+	const value = "aHVudGVyMg=="
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/secrets" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("labelSelector") != "created-by-prow = true" {
+			t.Errorf("Bad label selector: %s", r.URL.Query().Get("labelSelector"))
+		}
+		fmt.Fprintf(w, `{"items": [{"metadata": {"name": "a"}, "data": {"token": "%s"}}, {"metadata": {"name": "b"}}]}`, value)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	logger := &recordingLogger{}
+	c.Logger = logger
+	ss, err := c.ListSecrets(map[string]string{"created-by-prow": "true"})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(ss) != 2 || ss[0].Data["token"] != value {
+		t.Errorf("Wrong secrets: %v", ss)
+	}
+	for _, m := range logger.messages {
+		if strings.Contains(m, value) {
+			t.Errorf("Secret data was logged: %q", m)
+		}
+	}
+}
+
+func TestCreatePodGenerateName(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Pod
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if p.Metadata.Name != "" {
+			t.Errorf("Expected no name alongside generateName, got %s", p.Metadata.Name)
+		}
+		p.Metadata.Name = p.Metadata.GenerateName + "x7k2q"
+		json.NewEncoder(w).Encode(p)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	pod, err := c.CreatePod(Pod{Metadata: ObjectMeta{GenerateName: "build-"}})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if pod.Metadata.Name != "build-x7k2q" {
+		t.Errorf("Expected the created pod to carry the generated name, got %q", pod.Metadata.Name)
+	}
+	name, err := c.CreatePodGenerateName("test-", Pod{Metadata: ObjectMeta{Name: "ignored"}})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if name != "test-x7k2q" {
+		t.Errorf("Expected generated name test-x7k2q, got %q", name)
+	}
+}
+
+func TestCreatePods(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		var p Pod
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if p.Metadata.Name == "b" {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "already exists")
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	var pods []Pod
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		pods = append(pods, Pod{Metadata: ObjectMeta{Name: name}})
+	}
+	created, errs := c.CreatePods(pods)
+	if len(created) != len(pods) || len(errs) != len(pods) {
+		t.Fatalf("Expected %d results, got %d pods and %d errors", len(pods), len(created), len(errs))
+	}
+	for i, p := range pods {
+		if p.Metadata.Name == "b" {
+			if !IsConflict(errs[i]) {
+				t.Errorf("Expected conflict creating b, got %v", errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("Didn't expect error creating %s: %v", p.Metadata.Name, errs[i])
+		}
+		if created[i].Metadata.Name != p.Metadata.Name {
+			t.Errorf("Expected created pod %s, got %s", p.Metadata.Name, created[i].Metadata.Name)
+		}
+	}
+
+	if _, errs := c.CreatePods(pods[:1]); errs != nil {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	other := c.WithNamespace("other")
+	if other.client != c.client || other.token != c.token {
+		t.Error("Expected the derived client to share the HTTP client and token.")
+	}
+	if _, err := other.GetPod("po"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if _, err := c.GetPod("po"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	expected := []string{"/api/v1/namespaces/other/pods/po", "/api/v1/namespaces/ns/pods/po"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, paths)
+	}
+}
+
+func TestPatchPod(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/pods/po" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/strategic-merge-patch+json" {
+			t.Errorf("Bad Content-Type: %s", r.Header.Get("Content-Type"))
+		}
+		var p Pod
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if p.Metadata.Labels["sinker"] != "delete" {
+			t.Errorf("Expected label in patch, got %v", p.Metadata.Labels)
+		}
+		fmt.Fprint(w, `{"metadata": {"name": "po", "labels": {"existing": "label", "sinker": "delete"}}}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	po, err := c.PatchPod("po", Pod{Metadata: ObjectMeta{Labels: map[string]string{"sinker": "delete"}}})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(po.Metadata.Labels) != 2 {
+		t.Errorf("Wrong labels: %v", po.Metadata.Labels)
+	}
+}
+
+func TestDeletePodResult(t *testing.T) {
+	var testcases = []struct {
+		name              string
+		body              string
+		expectName        string
+		expectTerminating bool
+	}{
+		{
+			name:              "terminating",
+			body:              `{"metadata": {"name": "po", "deletionTimestamp": "2017-06-01T12:00:30Z"}}`,
+			expectName:        "po",
+			expectTerminating: true,
+		},
+		{
+			name:       "deleted immediately",
+			body:       `{"metadata": {"name": "po"}}`,
+			expectName: "po",
+		},
+		{
+			name: "status",
+			body: `{"kind": "Status", "status": "Success"}`,
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodDelete {
+				t.Errorf("Bad method: %s", r.Method)
+			}
+			if r.URL.Path != "/api/v1/namespaces/ns/pods/po" {
+				t.Errorf("Bad request path: %s", r.URL.Path)
+			}
+			fmt.Fprint(w, tc.body)
+		}))
+		c := getClient(ts.URL)
+		po, err := c.DeletePodResult("po")
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		if po.Metadata.Name != tc.expectName {
+			t.Errorf("%s: wrong name: %s", tc.name, po.Metadata.Name)
+		}
+		if terminating := po.Metadata.DeletionTimestamp != nil; terminating != tc.expectTerminating {
+			t.Errorf("%s: expected terminating %t, got %t", tc.name, tc.expectTerminating, terminating)
+		}
+	}
+}
+
+func TestPatchSecret(t *testing.T) {
+	const value = "aHVudGVyMg=="
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/secrets/hmac-token" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			t.Errorf("Bad Content-Type: %s", r.Header.Get("Content-Type"))
+		}
+		var s Secret
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if !reflect.DeepEqual(s.Data, map[string]string{"hook": value}) {
+			t.Errorf("Expected only the patched key, got %v", s.Data)
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	logger := &recordingLogger{}
+	c.Logger = logger
+	if err := c.PatchSecret("hmac-token", Secret{Data: map[string]string{"hook": value}}); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	for _, m := range logger.messages {
+		if strings.Contains(m, value) {
+			t.Errorf("Secret data was logged: %q", m)
+		}
+	}
+}
+
+func TestDialer(t *testing.T) {
+	var testcases = []struct {
+		name            string
+		cfg             ClientConfig
+		expectTimeout   time.Duration
+		expectKeepAlive time.Duration
+	}{
+		{
+			name:            "defaults",
+			expectTimeout:   defaultDialTimeout,
+			expectKeepAlive: defaultKeepAlive,
+		},
+		{
+			name:            "configured",
+			cfg:             ClientConfig{DialTimeout: 50 * time.Millisecond, KeepAlive: time.Second},
+			expectTimeout:   50 * time.Millisecond,
+			expectKeepAlive: time.Second,
+		},
+	}
+	for _, tc := range testcases {
+		d := newDialer(tc.cfg)
+		if d.Timeout != tc.expectTimeout {
+			t.Errorf("%s: expected dial timeout %v, got %v", tc.name, tc.expectTimeout, d.Timeout)
+		}
+		if d.KeepAlive != tc.expectKeepAlive {
+			t.Errorf("%s: expected keep-alive %v, got %v", tc.name, tc.expectKeepAlive, d.KeepAlive)
+		}
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Accept the request but never respond.
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	tr := newTransport(ClientConfig{ResponseHeaderTimeout: 50 * time.Millisecond}, nil)
+	c := &http.Client{Transport: tr}
+	_, err := c.Get(ts.URL)
+	if err == nil {
+		t.Fatal("Expected error from a server that never responds.")
+	}
+	if !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Errorf("Expected a response header timeout, got %v", err)
+	}
+}
+
+func TestTransportDefaults(t *testing.T) {
+	tr := newTransport(ClientConfig{}, nil)
+	if tr.TLSHandshakeTimeout != defaultTLSHandshakeTimeout {
+		t.Errorf("Wrong TLS handshake timeout: %v", tr.TLSHandshakeTimeout)
+	}
+	if tr.ResponseHeaderTimeout != defaultResponseHeaderTimeout {
+		t.Errorf("Wrong response header timeout: %v", tr.ResponseHeaderTimeout)
+	}
+}
+
+func TestTransportHTTP2Pings(t *testing.T) {
+	var testcases = []struct {
+		name        string
+		cfg         ClientConfig
+		readIdle    time.Duration
+		pingTimeout time.Duration
+	}{
+		{
+			name:        "defaults",
+			readIdle:    defaultHTTP2ReadIdleTimeout,
+			pingTimeout: defaultHTTP2PingTimeout,
+		},
+		{
+			name:        "configured",
+			cfg:         ClientConfig{HTTP2ReadIdleTimeout: time.Second, HTTP2PingTimeout: 2 * time.Second},
+			readIdle:    time.Second,
+			pingTimeout: 2 * time.Second,
+		},
+	}
+	for _, tc := range testcases {
+		tr := newTransport(tc.cfg, nil)
+		if !tr.ForceAttemptHTTP2 {
+			t.Errorf("%s: expected HTTP/2 to be enabled", tc.name)
+		}
+		if tr.HTTP2 == nil {
+			t.Errorf("%s: expected HTTP/2 to be configured", tc.name)
+			continue
+		}
+		if tr.HTTP2.SendPingTimeout != tc.readIdle || tr.HTTP2.PingTimeout != tc.pingTimeout {
+			t.Errorf("%s: expected pings after %v with timeout %v, got %v and %v", tc.name, tc.readIdle, tc.pingTimeout, tr.HTTP2.SendPingTimeout, tr.HTTP2.PingTimeout)
+		}
+	}
+}
+
+type stubRoundTripper struct {
+	next     http.RoundTripper
+	requests []*http.Request
+}
+
+func (s *stubRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	s.requests = append(s.requests, r)
+	return s.next.RoundTrip(r)
+}
+
+func TestWrapTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	stub := &stubRoundTripper{}
+	c := getClient(ts.URL)
+	c.client = newHTTPClient(ClientConfig{
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			if _, ok := rt.(*http.Transport); !ok {
+				t.Errorf("Expected to wrap the client's own transport, got %T", rt)
+			}
+			stub.next = rt
+			return stub
+		},
+	}, nil)
+	if _, err := c.GetPod("po"); err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(stub.requests) != 1 {
+		t.Fatalf("Expected one request through the wrapper, got %d", len(stub.requests))
+	}
+	r := stub.requests[0]
+	if r.URL.Path != "/api/v1/namespaces/ns/pods/po" {
+		t.Errorf("Bad request path: %s", r.URL.Path)
+	}
+	if r.Header.Get("Authorization") != "Bearer abcd" {
+		t.Errorf("Bad authorization header: %s", r.Header.Get("Authorization"))
+	}
+	if r.Header.Get("Audit-ID") == "" {
+		t.Error("Expected an Audit-ID header.")
+	}
+}
+
+func TestGetAllContainerLogs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/ns/pods/po":
+			fmt.Fprint(w, `{"spec": {"initContainers": [{"name": "clone"}], "containers": [{"name": "test"}, {"name": "sidecar"}]}}`)
+		case "/api/v1/namespaces/ns/pods/po/log":
+			switch container := r.URL.Query().Get("container"); container {
+			case "clone", "test":
+				fmt.Fprintf(w, "%s log", container)
+			case "sidecar":
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "message": "container \"sidecar\" in pod \"po\" is waiting to start: ContainerCreating", "reason": "BadRequest", "code": 400}`)
+			default:
+				t.Errorf("Bad container: %s", container)
+			}
+		default:
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	logs, err := c.GetAllContainerLogs("po")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	expected := map[string]string{
+		"clone":   "clone log",
+		"test":    "test log",
+		"sidecar": "",
+	}
+	if !reflect.DeepEqual(logs, expected) {
+		t.Errorf("Expected logs %v, got %v", expected, logs)
+	}
+}
+
+func TestParseWarnings(t *testing.T) {
+	var testcases = []struct {
+		header   string
+		expected []string
+	}{
+		{
+			header:   `299 - "batch/v1beta1 CronJob is deprecated in v1.21+, unavailable in v1.25+"`,
+			expected: []string{"batch/v1beta1 CronJob is deprecated in v1.21+, unavailable in v1.25+"},
+		},
+		{
+			header:   `299 - "first", 299 - "second \"quoted\""`,
+			expected: []string{"first", `second "quoted"`},
+		},
+		{
+			header:   `299 - "dated" "Sat, 01 Jul 2017 00:00:00 GMT", 299 - "after"`,
+			expected: []string{"dated", "after"},
+		},
+		{
+			header: `299 - "unterminated`,
+		},
+	}
+	for _, tc := range testcases {
+		if got := parseWarnings(tc.header); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("For %s expected %q, got %q", tc.header, tc.expected, got)
+		}
+	}
+}
+
+func TestWarningHandler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Warning", `299 - "batch/v1beta1 Job is deprecated"`)
+		w.Header().Add("Warning", `299 - "another warning"`)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	var warnings []string
+	c.WarningHandler = func(warning string) {
+		warnings = append(warnings, warning)
+	}
+	if _, err := c.GetJob("jo"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	expected := []string{"batch/v1beta1 Job is deprecated", "another warning"}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings %q, got %q", expected, warnings)
+	}
+
+	c.WarningHandler = nil
+	logger := &recordingLogger{}
+	c.Logger = logger
+	if _, err := c.GetJob("jo"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	found := false
+	for _, m := range logger.messages {
+		if strings.Contains(m, "batch/v1beta1 Job is deprecated") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the warning to be logged, got %q", logger.messages)
+	}
+}
+
+func TestSetJobCondition(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/apis/batch/v1/namespaces/ns/jobs/jo/status" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/strategic-merge-patch+json" {
+			t.Errorf("Bad Content-Type: %s", r.Header.Get("Content-Type"))
+		}
+		var patch map[string]map[string][]map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if len(patch) != 1 || len(patch["status"]) != 1 || len(patch["status"]["conditions"]) != 1 {
+			t.Errorf("Expected only a single condition in the patch, got %v", patch)
+			return
+		}
+		cond := patch["status"]["conditions"][0]
+		if cond["type"] != "Failed" || cond["status"] != "True" || cond["reason"] != "DeadlineExceeded" {
+			t.Errorf("Wrong condition: %v", cond)
+		}
+		if cond["lastTransitionTime"] == "" {
+			t.Error("Expected lastTransitionTime to be set.")
+		}
+		if _, ok := cond["lastProbeTime"]; ok {
+			t.Error("Didn't expect an unset lastProbeTime to be sent.")
+		}
+		fmt.Fprint(w, `{"metadata": {"name": "jo"}}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	_, err := c.SetJobCondition("jo", JobCondition{Type: JobFailed, Status: "True", Reason: "DeadlineExceeded"})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		// Drop the connection so that the client sees a transport error.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Couldn't hijack: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL).WithoutRetries()
+	start := time.Now()
+	if _, err := c.CreatePod(Pod{}); err == nil {
+		t.Error("Expected error.")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected a single attempt, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed >= retryDelay {
+		t.Errorf("Expected no backoff after the only attempt, took %v", elapsed)
+	}
+}
+
+func TestListEventsForJob(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/events" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("fieldSelector") != "involvedObject.kind=Job,involvedObject.name=jo" {
+			t.Errorf("Bad field selector: %s", r.URL.Query().Get("fieldSelector"))
+		}
+		fmt.Fprint(w, `{"items": [{"reason": "FailedCreate", "message": "exceeded quota"}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	es, err := c.ListEventsForJob("jo")
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(es) != 1 || es[0].Reason != "FailedCreate" {
+		t.Errorf("Wrong events: %+v", es)
+	}
+}
+
+func TestClose(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.client = &http.Client{Transport: &http.Transport{}}
+	if _, err := c.GetPod("po"); err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	c.Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Idle connection wasn't closed.")
+	}
+	// Close is idempotent and a no-op on fake clients.
+	c.Close()
+	NewFakeClient().Close()
+}
+
+func TestListOptionsQuery(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		opts     ListOptions
+		expected map[string]string
+	}{
+		{
+			name:     "unset fields are omitted",
+			expected: map[string]string{},
+		},
+		{
+			name:     "label selector",
+			opts:     ListOptions{LabelSelector: "a = b"},
+			expected: map[string]string{"labelSelector": "a = b"},
+		},
+		{
+			name:     "field selector",
+			opts:     ListOptions{FieldSelector: "status.phase=Running"},
+			expected: map[string]string{"fieldSelector": "status.phase=Running"},
+		},
+		{
+			name:     "pagination",
+			opts:     ListOptions{Limit: 50, Continue: "tok"},
+			expected: map[string]string{"limit": "50", "continue": "tok"},
+		},
+		{
+			name:     "resource version and timeout",
+			opts:     ListOptions{ResourceVersion: "123", TimeoutSeconds: 30},
+			expected: map[string]string{"resourceVersion": "123", "timeoutSeconds": "30"},
+		},
+	}
+	for _, tc := range testcases {
+		if q := tc.opts.query(); !reflect.DeepEqual(q, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, q)
+		}
+	}
+}
+
+func TestListPodsWithOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/ns/pods" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("limit") != "1" || q.Get("continue") != "" {
+			t.Errorf("Bad query: %v", q)
+		}
+		if _, ok := q["labelSelector"]; ok {
+			t.Error("Unset label selector shouldn't be sent.")
+		}
+		fmt.Fprint(w, `{"metadata": {"continue": "next"}, "items": [{}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	pl, err := c.ListPodsWithOptions(ListOptions{Limit: 1})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(pl.Items) != 1 || pl.Metadata.Continue != "next" {
+		t.Errorf("Wrong list: %+v", pl)
+	}
+}
+
+func TestListJobsWithOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/batch/v1/namespaces/ns/jobs" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("fieldSelector") != "status.successful=1" {
+			t.Errorf("Bad field selector: %s", r.URL.Query().Get("fieldSelector"))
+		}
+		fmt.Fprint(w, `{"items": [{}, {}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	jl, err := c.ListJobsWithOptions(ListOptions{FieldSelector: "status.successful=1"})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(jl.Items) != 2 {
+		t.Error("Expected two jobs.")
+	}
+}
+
+func TestEvictPod(t *testing.T) {
+	var testcases = []struct {
+		name         string
+		code         int
+		body         string
+		expectErr    bool
+		expectPDBErr bool
+	}{
+		{
+			name: "evicted",
+			code: http.StatusCreated,
+			body: `{"kind": "Status", "status": "Success", "code": 201}`,
+		},
+		{
+			name:         "blocked by disruption budget",
+			code:         http.StatusTooManyRequests,
+			body:         `{"kind": "Status", "status": "Failure", "reason": "TooManyRequests", "message": "Cannot evict pod as it would violate the pod's disruption budget.", "code": 429}`,
+			expectErr:    true,
+			expectPDBErr: true,
+		},
+		{
+			name:      "missing pod",
+			code:      http.StatusNotFound,
+			body:      `{"kind": "Status", "status": "Failure", "reason": "NotFound", "code": 404}`,
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				t.Errorf("%s: bad method: %s", tc.name, r.Method)
+			}
+			if r.URL.Path != "/api/v1/namespaces/ns/pods/po/eviction" {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			var ev Eviction
+			if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+				t.Errorf("%s: couldn't decode eviction: %v", tc.name, err)
+			}
+			if ev.APIVersion != "policy/v1" || ev.Kind != "Eviction" || ev.Metadata.Name != "po" || ev.Metadata.Namespace != "ns" {
+				t.Errorf("%s: bad eviction: %+v", tc.name, ev)
+			}
+			w.WriteHeader(tc.code)
+			fmt.Fprint(w, tc.body)
+		}))
+		c := getClient(ts.URL)
+		err := c.EvictPod("po")
+		ts.Close()
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.expectErr, err)
+		}
+		if _, ok := err.(DisruptionBudgetError); ok != tc.expectPDBErr {
+			t.Errorf("%s: expected DisruptionBudgetError %t, got %v", tc.name, tc.expectPDBErr, err)
+		}
+	}
+}
+
+func TestDrainPod(t *testing.T) {
+	const blocked = `{"kind": "Status", "status": "Failure", "reason": "TooManyRequests", "message": "Cannot evict pod as it would violate the pod's disruption budget.", "code": 429}`
+	var testcases = []struct {
+		name      string
+		codes     []int
+		timeout   bool
+		expectErr bool
+	}{
+		{
+			name:  "blocked then evicted",
+			codes: []int{http.StatusTooManyRequests, http.StatusCreated},
+		},
+		{
+			name:  "already gone",
+			codes: []int{http.StatusNotFound},
+		},
+		{
+			name:      "blocked until timeout",
+			codes:     []int{http.StatusTooManyRequests},
+			timeout:   true,
+			expectErr: true,
+		},
+		{
+			name:      "permanent failure",
+			codes:     []int{http.StatusForbidden},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		evictions := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/namespaces/ns/pods/po/eviction" {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			code := tc.codes[len(tc.codes)-1]
+			if evictions < len(tc.codes) {
+				code = tc.codes[evictions]
+			}
+			evictions++
+			w.WriteHeader(code)
+			switch code {
+			case http.StatusTooManyRequests:
+				fmt.Fprint(w, blocked)
+			default:
+				fmt.Fprintf(w, `{"kind": "Status", "code": %d}`, code)
+			}
+		}))
+		c := getClient(ts.URL)
+		c.PollInterval = time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := c.DrainPod(ctx, "po")
+		cancel()
+		ts.Close()
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.expectErr, err)
+		}
+		if _, ok := err.(DrainTimeoutError); ok != tc.timeout {
+			t.Errorf("%s: expected DrainTimeoutError %t, got %v", tc.name, tc.timeout, err)
+		}
+		if !tc.timeout && evictions != len(tc.codes) {
+			t.Errorf("%s: expected %d evictions, got %d", tc.name, len(tc.codes), evictions)
+		}
+	}
+}
+
+func TestListPodsWithAnnotations(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelSelector") != "created-by-prow = true" {
+			t.Errorf("Bad label selector: %s", r.URL.Query().Get("labelSelector"))
+		}
+		fmt.Fprint(w, `{"items": [
+			{"metadata": {"name": "a", "annotations": {"prow.k8s.io/job": "x"}}},
+			{"metadata": {"name": "b", "annotations": {"prow.k8s.io/job": "y"}}},
+			{"metadata": {"name": "c"}},
+			{"metadata": {"name": "d", "annotations": {"prow.k8s.io/job": "x", "other": "z"}}}
+		]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	pods, err := c.ListPodsWithAnnotations(map[string]string{"created-by-prow": "true"}, map[string]string{"prow.k8s.io/job": "x"})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	var names []string
+	for _, p := range pods {
+		names = append(names, p.Metadata.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "d"}) {
+		t.Errorf("Wrong pods: %v", names)
+	}
+}
+
+func TestListPodsByOwner(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelSelector") != "job-name = jo" {
+			t.Errorf("Bad label selector: %s", r.URL.Query().Get("labelSelector"))
+		}
+		fmt.Fprint(w, `{"items": [
+			{"metadata": {"name": "a", "ownerReferences": [{"kind": "Job", "name": "jo", "uid": "1234"}]}},
+			{"metadata": {"name": "b", "ownerReferences": [{"kind": "Job", "name": "jo", "uid": "5678"}]}},
+			{"metadata": {"name": "c"}},
+			{"metadata": {"name": "d", "ownerReferences": [{"kind": "ConfigMap", "name": "cm", "uid": "9999"}, {"kind": "Job", "name": "jo", "uid": "1234"}]}}
+		]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	pods, err := c.ListPodsByOwner("1234", map[string]string{"job-name": "jo"})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	var names []string
+	for _, p := range pods {
+		names = append(names, p.Metadata.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "d"}) {
+		t.Errorf("Wrong pods: %v", names)
+	}
+}
+
+func TestGetPodStatus(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		pod      string
+		expected PodStatusSummary
+	}{
+		{
+			name: "running",
+			pod: `{"status": {"phase": "Running",
+				"conditions": [{"type": "Ready", "status": "True"}],
+				"containerStatuses": [{"name": "test", "ready": true, "state": {"running": {"startedAt": "2017-06-01T00:00:00Z"}}}]}}`,
+			expected: PodStatusSummary{
+				Phase:      PodRunning,
+				Ready:      true,
+				Containers: []ContainerSummary{{Name: "test", Ready: true, State: "Running"}},
+			},
+		},
+		{
+			name: "crash looping",
+			pod: `{"status": {"phase": "Running",
+				"conditions": [{"type": "Ready", "status": "False", "reason": "ContainersNotReady"}],
+				"containerStatuses": [{"name": "test", "restartCount": 4, "state": {"waiting": {"reason": "CrashLoopBackOff"}}}]}}`,
+			expected: PodStatusSummary{
+				Phase:      PodRunning,
+				Containers: []ContainerSummary{{Name: "test", RestartCount: 4, State: "Waiting", Reason: "CrashLoopBackOff"}},
+			},
+		},
+		{
+			name: "completed",
+			pod: `{"status": {"phase": "Succeeded",
+				"containerStatuses": [{"name": "test", "state": {"terminated": {"exitCode": 0, "reason": "Completed"}}}]}}`,
+			expected: PodStatusSummary{
+				Phase:      PodSucceeded,
+				Containers: []ContainerSummary{{Name: "test", State: "Terminated", Reason: "Completed"}},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/namespaces/ns/pods/po" {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			fmt.Fprint(w, tc.pod)
+		}))
+		c := getClient(ts.URL)
+		s, err := c.GetPodStatus("po")
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		} else if !reflect.DeepEqual(s, tc.expected) {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.expected, s)
+		}
+	}
+}
+
+func TestForbiddenError(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		body     string
+		verb     string
+		resource string
+	}{
+		{
+			name:     "RBAC denial",
+			body:     `{"kind": "Status", "status": "Failure", "message": "pods is forbidden: User \"system:serviceaccount:ns:plank\" cannot list resource \"pods\" in API group \"\" in the namespace \"ns\"", "reason": "Forbidden", "code": 403}`,
+			verb:     "list",
+			resource: "pods",
+		},
+		{
+			name:     "older RBAC denial",
+			body:     `{"kind": "Status", "status": "Failure", "message": "User \"system:serviceaccount:ns:plank\" cannot delete pods in the namespace \"ns\".", "reason": "Forbidden", "code": 403}`,
+			verb:     "delete",
+			resource: "pods",
+		},
+		{
+			name: "no details",
+			body: `forbidden`,
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, tc.body)
+		}))
+		c := getClient(ts.URL)
+		_, err := c.ListPods(nil)
+		ts.Close()
+		if !IsForbidden(err) {
+			t.Errorf("%s: expected a ForbiddenError, got %v", tc.name, err)
+			continue
+		}
+		fe := err.(ForbiddenError)
+		if fe.Verb != tc.verb || fe.Resource != tc.resource {
+			t.Errorf("%s: expected %q %q, got %q %q", tc.name, tc.verb, tc.resource, fe.Verb, fe.Resource)
+		}
+	}
+}
+
+func TestPatchRaw(t *testing.T) {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{"remove-me": nil},
+		},
+		"spec": map[string]interface{}{"parallelism": 0},
+	}
+	expected := `{"metadata":{"annotations":{"remove-me":null}},"spec":{"parallelism":0}}`
+	var testcases = []struct {
+		name  string
+		path  string
+		patch func(c *Client) error
+	}{
+		{
+			name: "job",
+			path: "/apis/batch/v1/namespaces/ns/jobs/jo",
+			patch: func(c *Client) error {
+				_, err := c.PatchJobRaw("jo", patch)
+				return err
+			},
+		},
+		{
+			name: "pod",
+			path: "/api/v1/namespaces/ns/pods/po",
+			patch: func(c *Client) error {
+				_, err := c.PatchPodRaw("po", patch)
+				return err
+			},
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPatch {
+				t.Errorf("%s: bad method: %s", tc.name, r.Method)
+			}
+			if r.URL.Path != tc.path {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("%s: couldn't read body: %v", tc.name, err)
+			}
+			if string(b) != expected {
+				t.Errorf("%s: expected patch %s, got %s", tc.name, expected, string(b))
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+		c := getClient(ts.URL)
+		err := tc.patch(c)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+	}
+}
+
+func TestAddLabelsAndAnnotations(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		path     string
+		expected string
+		add      func(c *Client) error
+	}{
+		{
+			name:     "pod label",
+			path:     "/api/v1/namespaces/ns/pods/po",
+			expected: `{"metadata":{"labels":{"prow.k8s.io/job":"ci-foo"}}}`,
+			add: func(c *Client) error {
+				_, err := c.AddPodLabel("po", "prow.k8s.io/job", "ci-foo")
+				return err
+			},
+		},
+		{
+			name:     "pod annotation",
+			path:     "/api/v1/namespaces/ns/pods/po",
+			expected: `{"metadata":{"annotations":{"prow.k8s.io/safe-to-delete":"true"}}}`,
+			add: func(c *Client) error {
+				_, err := c.AddPodAnnotation("po", "prow.k8s.io/safe-to-delete", "true")
+				return err
+			},
+		},
+		{
+			name:     "job label",
+			path:     "/apis/batch/v1/namespaces/ns/jobs/jo",
+			expected: `{"metadata":{"labels":{"prow.k8s.io/job":"ci-foo"}}}`,
+			add: func(c *Client) error {
+				_, err := c.AddJobLabel("jo", "prow.k8s.io/job", "ci-foo")
+				return err
+			},
+		},
+		{
+			name:     "job annotation",
+			path:     "/apis/batch/v1/namespaces/ns/jobs/jo",
+			expected: `{"metadata":{"annotations":{"state":"success"}}}`,
+			add: func(c *Client) error {
+				_, err := c.AddJobAnnotation("jo", "state", "success")
+				return err
+			},
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPatch {
+				t.Errorf("%s: bad method: %s", tc.name, r.Method)
+			}
+			if r.URL.Path != tc.path {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("%s: couldn't read body: %v", tc.name, err)
+			}
+			if string(b) != tc.expected {
+				t.Errorf("%s: expected patch %s, got %s", tc.name, tc.expected, string(b))
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+		c := getClient(ts.URL)
+		err := tc.add(c)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+	}
+}
+
+func TestResourceQuotas(t *testing.T) {
+	quota := `{"metadata": {"name": "compute"}, "status": {"hard": {"pods": "10", "requests.cpu": "4"}, "used": {"pods": "7", "requests.cpu": "3500m"}}}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/ns/resourcequotas/compute":
+			fmt.Fprint(w, quota)
+		case "/api/v1/namespaces/ns/resourcequotas":
+			fmt.Fprintf(w, `{"items": [%s]}`, quota)
+		default:
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	q, err := c.GetResourceQuota("compute")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if q.Status.Hard["pods"] != "10" || q.Status.Used["requests.cpu"] != "3500m" {
+		t.Errorf("Wrong quota: %+v", q)
+	}
+	qs, err := c.ListResourceQuotas()
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(qs) != 1 || qs[0].Status.Used["pods"] != "7" {
+		t.Errorf("Wrong quotas: %+v", qs)
+	}
+}
+
+func TestCreatePVC(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/persistentvolumeclaims" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		var pvc PersistentVolumeClaim
+		if err := json.NewDecoder(r.Body).Decode(&pvc); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if pvc.Spec.StorageClassName != "ssd" || pvc.Spec.Resources.Requests["storage"] != "10Gi" {
+			t.Errorf("Wrong claim: %+v", pvc)
+		}
+		pvc.Status.Phase = ClaimPending
+		json.NewEncoder(w).Encode(pvc)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	pvc, err := c.CreatePVC(PersistentVolumeClaim{
+		Metadata: ObjectMeta{Name: "cache"},
+		Spec: PersistentVolumeClaimSpec{
+			AccessModes:      []string{"ReadWriteOnce"},
+			StorageClassName: "ssd",
+			Resources:        VolumeResourceRequests{Requests: map[string]string{"storage": "10Gi"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if pvc.Metadata.Name != "cache" || pvc.Status.Phase != ClaimPending {
+		t.Errorf("Wrong claim: %+v", pvc)
+	}
+}
+
+func TestGetPVC(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		body     string
+		expected PersistentVolumeClaimPhase
+	}{
+		{
+			name:     "waiting for a volume",
+			body:     `{"metadata": {"name": "cache"}, "spec": {"resources": {"requests": {"storage": "10Gi"}}}, "status": {"phase": "Pending"}}`,
+			expected: ClaimPending,
+		},
+		{
+			name:     "bound to a volume",
+			body:     `{"metadata": {"name": "cache"}, "spec": {"resources": {"requests": {"storage": "10Gi"}}}, "status": {"phase": "Bound"}}`,
+			expected: ClaimBound,
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v1/namespaces/ns/persistentvolumeclaims/cache":
+				fmt.Fprint(w, tc.body)
+			case "/api/v1/namespaces/ns/persistentvolumeclaims":
+				fmt.Fprintf(w, `{"items": [%s]}`, tc.body)
+			default:
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+		}))
+		c := getClient(ts.URL)
+		pvc, err := c.GetPVC("cache")
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		if pvc.Status.Phase != tc.expected || pvc.Spec.Resources.Requests["storage"] != "10Gi" {
+			t.Errorf("%s: wrong claim: %+v", tc.name, pvc)
+		}
+		pvcs, err := c.ListPVCs(nil)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		if len(pvcs) != 1 || pvcs[0].Status.Phase != tc.expected {
+			t.Errorf("%s: wrong claims: %+v", tc.name, pvcs)
+		}
+	}
+}
+
+func TestLimitRanges(t *testing.T) {
+	limits := `{"metadata": {"name": "defaults"}, "spec": {"limits": [{"type": "Container", "default": {"cpu": "1", "memory": "1Gi"}, "defaultRequest": {"cpu": "500m", "memory": "512Mi"}, "max": {"cpu": "4"}, "min": {"cpu": "100m"}}]}}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/ns/limitranges/defaults":
+			fmt.Fprint(w, limits)
+		case "/api/v1/namespaces/ns/limitranges":
+			fmt.Fprintf(w, `{"items": [%s]}`, limits)
+		default:
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	lr, err := c.GetLimitRange("defaults")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(lr.Spec.Limits) != 1 {
+		t.Fatalf("Expected one limit, got %+v", lr)
+	}
+	l := lr.Spec.Limits[0]
+	if l.Type != "Container" || l.Default["memory"] != "1Gi" || l.DefaultRequest["cpu"] != "500m" || l.Max["cpu"] != "4" || l.Min["cpu"] != "100m" {
+		t.Errorf("Wrong limit: %+v", l)
+	}
+	lrs, err := c.ListLimitRanges()
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(lrs) != 1 || len(lrs[0].Spec.Limits) != 1 || lrs[0].Spec.Limits[0].DefaultRequest["memory"] != "512Mi" {
+		t.Errorf("Wrong limit ranges: %+v", lrs)
+	}
+}
+
+func TestGetWithOptions(t *testing.T) {
+	var testcases = []struct {
+		name string
+		opts GetOptions
+		path string
+		get  func(c *Client, opts GetOptions) error
+	}{
+		{
+			name: "cached pod read",
+			opts: GetOptions{ResourceVersion: "0"},
+			path: "/api/v1/namespaces/ns/pods/po",
+			get: func(c *Client, opts GetOptions) error {
+				_, err := c.GetPodWithOptions("po", opts)
+				return err
+			},
+		},
+		{
+			name: "plain pod read",
+			path: "/api/v1/namespaces/ns/pods/po",
+			get: func(c *Client, opts GetOptions) error {
+				_, err := c.GetPodWithOptions("po", opts)
+				return err
+			},
+		},
+		{
+			name: "cached job read",
+			opts: GetOptions{ResourceVersion: "0"},
+			path: "/apis/batch/v1/namespaces/ns/jobs/jo",
+			get: func(c *Client, opts GetOptions) error {
+				_, err := c.GetJobWithOptions("jo", opts)
+				return err
+			},
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != tc.path {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			rv, ok := r.URL.Query()["resourceVersion"]
+			if tc.opts.ResourceVersion == "" && ok {
+				t.Errorf("%s: unset resourceVersion shouldn't be sent", tc.name)
+			} else if tc.opts.ResourceVersion != "" && (len(rv) != 1 || rv[0] != tc.opts.ResourceVersion) {
+				t.Errorf("%s: bad resourceVersion: %v", tc.name, rv)
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+		c := getClient(ts.URL)
+		err := tc.get(c, tc.opts)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+	}
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	c.MaxRequestBytes = 1024
+	if _, err := c.CreatePod(Pod{Metadata: ObjectMeta{Name: "small"}}); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	big := Pod{Metadata: ObjectMeta{Annotations: map[string]string{"a": strings.Repeat("x", 2048)}}}
+	_, err := c.CreatePod(big)
+	if _, ok := err.(RequestTooLargeError); !ok {
+		t.Errorf("Expected a RequestTooLargeError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the oversized request not to be sent, got %d requests", calls)
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	err := c.request(&request{
+		method:      http.MethodPost,
+		path:        "/api/v1/namespaces/ns/configmaps",
+		requestBody: map[string]interface{}{"data": make(chan int)},
+	}, nil)
+	if _, ok := err.(MarshalError); !ok {
+		t.Errorf("Expected a MarshalError, got %v", err)
+	} else if msg := err.Error(); !strings.Contains(msg, "POST /api/v1/namespaces/ns/configmaps") || !strings.Contains(msg, "chan int") {
+		t.Errorf("Expected the error to name the request and the bad type, got %q", msg)
+	}
+	err = c.request(&request{
+		method: http.MethodPut,
+		path:   "/api/v1/namespaces/ns/configmaps/cm",
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "PUT /api/v1/namespaces/ns/configmaps/cm has no request body") {
+		t.Errorf("Expected a missing body error, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no requests to be sent, got %d", calls)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var testcases = []struct {
+		name      string
+		userAgent string
+		expected  string
+	}{
+		{
+			name:     "default",
+			expected: "prow",
+		},
+		{
+			name:      "configured",
+			userAgent: "plank/v20170601",
+			expected:  "plank/v20170601",
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ua := r.Header.Get("User-Agent"); ua != tc.expected {
+				t.Errorf("%s: expected User-Agent %q for %s, got %q", tc.name, tc.expected, r.Method, ua)
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+		c := getClient(ts.URL)
+		c.UserAgent = tc.userAgent
+		if _, err := c.GetPod("po"); err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		if _, err := c.CreatePod(Pod{}); err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		ts.Close()
+	}
+}
+
+func TestFieldManager(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		manager  string
+		expected string
+	}{
+		{
+			name:     "default",
+			expected: "prow",
+		},
+		{
+			name:     "configured",
+			manager:  "plank",
+			expected: "plank",
+		},
+	}
+	writes := map[string]func(c *Client) error{
+		"CreatePod": func(c *Client) error {
+			_, err := c.CreatePod(Pod{})
+			return err
+		},
+		"CreateJob": func(c *Client) error {
+			_, err := c.CreateJob(Job{})
+			return err
+		},
+		"PatchJob": func(c *Client) error {
+			_, err := c.PatchJob("jo", Job{})
+			return err
+		},
+		"ReplaceSecret": func(c *Client) error {
+			return c.ReplaceSecret("se", Secret{})
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			manager, ok := r.URL.Query()["fieldManager"]
+			if r.Method == http.MethodGet {
+				if ok {
+					t.Errorf("%s: didn't expect a field manager on a read, got %v", tc.name, manager)
+				}
+			} else if len(manager) != 1 || manager[0] != tc.expected {
+				t.Errorf("%s: expected field manager %s for %s %s, got %v", tc.name, tc.expected, r.Method, r.URL.Path, manager)
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+		c := getClient(ts.URL)
+		c.FieldManager = tc.manager
+		for method, write := range writes {
+			if err := write(c); err != nil {
+				t.Errorf("%s: %s failed: %v", tc.name, method, err)
+			}
+		}
+		if _, err := c.GetPod("po"); err != nil {
+			t.Errorf("%s: GetPod failed: %v", tc.name, err)
+		}
+		ts.Close()
+	}
+}
+
+func TestCanI(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		verb     string
+		resource string
+		expected ResourceAttributes
+		response string
+		allowed  bool
+	}{
+		{
+			name:     "allowed",
+			verb:     "create",
+			resource: "pods",
+			expected: ResourceAttributes{Namespace: "ns", Verb: "create", Resource: "pods"},
+			response: `{"kind": "SelfSubjectAccessReview", "status": {"allowed": true, "reason": "RBAC: allowed by RoleBinding \"plank/ns\""}}`,
+			allowed:  true,
+		},
+		{
+			name:     "denied",
+			verb:     "patch",
+			resource: "jobs.batch",
+			expected: ResourceAttributes{Namespace: "ns", Verb: "patch", Group: "batch", Resource: "jobs"},
+			response: `{"kind": "SelfSubjectAccessReview", "status": {"allowed": false}}`,
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				t.Errorf("%s: bad method: %s", tc.name, r.Method)
+			}
+			if r.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			var review SelfSubjectAccessReview
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				t.Errorf("%s: couldn't decode review: %v", tc.name, err)
+			}
+			if review.Kind != "SelfSubjectAccessReview" || review.Spec.ResourceAttributes == nil ||
+				*review.Spec.ResourceAttributes != tc.expected {
+				t.Errorf("%s: bad review: %+v", tc.name, review)
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, tc.response)
+		}))
+		c := getClient(ts.URL)
+		allowed, err := c.CanI(tc.verb, tc.resource)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		if allowed != tc.allowed {
+			t.Errorf("%s: expected allowed %t, got %t", tc.name, tc.allowed, allowed)
+		}
+	}
+}
+
+func TestPatchPodStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path != "/api/v1/namespaces/ns/pods/po/status" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		var p Pod
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		if len(p.Status.Conditions) != 1 || p.Status.Conditions[0].Type != "prow.k8s.io/ready" || p.Status.Conditions[0].Status != "True" {
+			t.Errorf("Expected the condition in the patch, got %+v", p.Status.Conditions)
+		}
+		fmt.Fprint(w, `{"status": {"conditions": [{"type": "Ready", "status": "True"}, {"type": "prow.k8s.io/ready", "status": "True"}]}}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	po, err := c.PatchPodStatus("po", Pod{Status: PodStatus{Conditions: []PodCondition{{Type: "prow.k8s.io/ready", Status: "True"}}}})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(po.Status.Conditions) != 2 {
+		t.Errorf("Wrong conditions: %+v", po.Status.Conditions)
+	}
+}
+
+func TestGetLogSince(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		since    time.Time
+		expected string
+	}{
+		{
+			name: "zero time means the whole log",
+		},
+		{
+			name:     "RFC3339 in UTC",
+			since:    time.Date(2017, 6, 1, 12, 30, 0, 0, time.FixedZone("PDT", -7*60*60)),
+			expected: "2017-06-01T19:30:00Z",
+		},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/namespaces/ns/pods/po/log" {
+				t.Errorf("%s: bad request path: %s", tc.name, r.URL.Path)
+			}
+			since, ok := r.URL.Query()["sinceTime"]
+			if tc.expected == "" && ok {
+				t.Errorf("%s: expected no sinceTime, got %v", tc.name, since)
+			} else if tc.expected != "" && (len(since) != 1 || since[0] != tc.expected) {
+				t.Errorf("%s: expected sinceTime %s, got %v", tc.name, tc.expected, since)
+			}
+			fmt.Fprint(w, "log")
+		}))
+		c := getClient(ts.URL)
+		log, err := c.GetLogSince("po", tc.since)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		}
+		if string(log) != "log" {
+			t.Errorf("%s: wrong log: %q", tc.name, string(log))
+		}
+	}
+}
+
+type acceptGzipTransport struct {
+	next http.RoundTripper
+}
+
+func (a acceptGzipTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.Header.Set("Accept-Encoding", "gzip")
+	return a.next.RoundTrip(r)
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestGetLogGzip(t *testing.T) {
+	const log = "a build log that compresses well\n"
+	compressed, err := gzipBytes([]byte(strings.Repeat(log, 100)))
+	if err != nil {
+		t.Fatalf("Couldn't compress log: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("Expected the client to ask for gzip, got %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed)
+	}))
+	defer ts.Close()
+	var testcases = []struct {
+		name      string
+		transport http.RoundTripper
+	}{
+		{
+			name: "decompressed by the transport",
+		},
+		{
+			name:      "Accept-Encoding set elsewhere",
+			transport: acceptGzipTransport{next: &http.Transport{}},
+		},
+	}
+	for _, tc := range testcases {
+		c := getClient(ts.URL)
+		c.client = &http.Client{Transport: tc.transport}
+		logger := &recordingDebugLogger{}
+		c.Logger = logger
+		got, err := c.GetLog("po")
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+			continue
+		}
+		if string(got) != strings.Repeat(log, 100) {
+			t.Errorf("%s: expected the decompressed log, got %q", tc.name, string(got))
+		}
+		expected := fmt.Sprintf("Log of pod po is %d bytes", 100*len(log))
+		if len(logger.debug) != 1 || logger.debug[0] != expected {
+			t.Errorf("%s: expected debug log %q, got %v", tc.name, expected, logger.debug)
+		}
+	}
+}
+
+func TestGetLogs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pod := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/ns/pods/"), "/log")
+		if pod == "b" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","code":404,"reason":"NotFound"}`)
+			return
+		}
+		fmt.Fprintf(w, "log of %s", pod)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	pods := []string{"a", "b", "c", "d", "e", "f", "g"}
+	logs, errs := c.GetLogs(pods)
+	if len(errs) != len(pods) {
+		t.Fatalf("Expected %d errors, got %d", len(pods), len(errs))
+	}
+	for i, pod := range pods {
+		if pod == "b" {
+			if !IsNotFound(errs[i]) {
+				t.Errorf("Expected not found getting log of b, got %v", errs[i])
+			}
+			if _, ok := logs[pod]; ok {
+				t.Errorf("Didn't expect a log for b")
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("Didn't expect error getting log of %s: %v", pod, errs[i])
+		}
+		if expected := "log of " + pod; string(logs[pod]) != expected {
+			t.Errorf("Expected log %q, got %q", expected, string(logs[pod]))
+		}
+	}
+
+	if _, errs := c.GetLogs(pods[:1]); errs != nil {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+}
+
+func TestEmptyNamespace(t *testing.T) {
+	if _, err := NewClientInCluster(""); err == nil || !strings.Contains(err.Error(), "namespace") {
+		t.Errorf("Expected an empty namespace error, got %v", err)
+	}
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL).WithNamespace("")
+	if _, err := c.GetPod("po"); err == nil || !strings.Contains(err.Error(), "empty namespace") {
+		t.Errorf("Expected an empty namespace error, got %v", err)
+	}
+	// Cluster-scoped requests still work.
+	if _, err := c.GetNamespace("ns"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected only the cluster-scoped request to be sent, got %d requests", calls)
+	}
+}
+
+func TestListPodsOnNode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/ns/pods" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("labelSelector") != "created-by-prow = true" {
+			t.Errorf("Bad label selector: %s", r.URL.Query().Get("labelSelector"))
+		}
+		if r.URL.Query().Get("fieldSelector") != "spec.nodeName=node-1" {
+			t.Errorf("Bad field selector: %s", r.URL.Query().Get("fieldSelector"))
+		}
+		fmt.Fprint(w, `{"items": [{"spec": {"nodeName": "node-1"}}]}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	pods, err := c.ListPodsOnNode("node-1", map[string]string{"created-by-prow": "true"})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if len(pods) != 1 || pods[0].Spec.NodeName != "node-1" {
+		t.Errorf("Wrong pods: %+v", pods)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// discoveryCache remembers which resources each group version serves.
+type discoveryCache struct {
+	sync.Mutex
+	// resources is keyed by group version, then by resource name.
+	resources map[string]map[string]bool
+}
+
+func newDiscoveryCache() *discoveryCache {
+	return &discoveryCache{resources: map[string]map[string]bool{}}
+}
+
+// ServerSupportsResource reports whether the api-server serves resource in
+// groupVersion, for instance ("batch/v1", "jobs"). Use "v1" for the core
+// group. Subresources are named as in "pods/log". Results are cached for the
+// life of the client, since discovery rarely changes.
+func (c *Client) ServerSupportsResource(groupVersion, resource string) (bool, error) {
+	c.log("ServerSupportsResource", groupVersion, resource)
+	if c.discovery != nil {
+		c.discovery.Lock()
+		rs, ok := c.discovery.resources[groupVersion]
+		c.discovery.Unlock()
+		if ok {
+			return rs[resource], nil
+		}
+	}
+
+	path := "/apis/" + groupVersion
+	if groupVersion == "v1" {
+		path = "/api/v1"
+	}
+	var rl struct {
+		Resources []struct {
+			Name string `json:"name"`
+		} `json:"resources"`
+	}
+	err := c.request(&request{
+		method: http.MethodGet,
+		path:   path,
+	}, &rl)
+	if IsNotFound(err) {
+		// The whole group version is missing.
+		rl.Resources = nil
+	} else if err != nil {
+		return false, fmt.Errorf("discovering %s: %v", groupVersion, err)
+	}
+	rs := map[string]bool{}
+	for _, r := range rl.Resources {
+		rs[r.Name] = true
+	}
+	if c.discovery != nil {
+		c.discovery.Lock()
+		c.discovery.resources[groupVersion] = rs
+		c.discovery.Unlock()
+	}
+	return rs[resource], nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const batchV1Discovery = `{
+  "kind": "APIResourceList",
+  "apiVersion": "v1",
+  "groupVersion": "batch/v1",
+  "resources": [
+    {"name": "jobs", "singularName": "", "namespaced": true, "kind": "Job", "verbs": ["create", "delete", "get", "list", "patch", "update", "watch"]},
+    {"name": "jobs/status", "singularName": "", "namespaced": true, "kind": "Job", "verbs": ["get", "patch", "update"]}
+  ]
+}`
+
+func TestServerSupportsResource(t *testing.T) {
+	calls := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		switch r.URL.Path {
+		case "/apis/batch/v1":
+			fmt.Fprint(w, batchV1Discovery)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind": "APIResourceList", "groupVersion": "v1", "resources": [{"name": "pods"}]}`)
+		default:
+			// The api-server's NotFound handler doesn't send a Status.
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	var testcases = []struct {
+		groupVersion string
+		resource     string
+		expected     bool
+	}{
+		{"batch/v1", "jobs", true},
+		{"batch/v1", "jobs/status", true},
+		{"batch/v1", "cronjobs", false},
+		{"v1", "pods", true},
+		{"v1", "jobs", false},
+		{"metrics.k8s.io/v1beta1", "pods", false},
+		// Repeat lookups are served from the cache.
+		{"batch/v1", "jobs", true},
+		{"metrics.k8s.io/v1beta1", "nodes", false},
+	}
+	for _, tc := range testcases {
+		ok, err := c.ServerSupportsResource(tc.groupVersion, tc.resource)
+		if err != nil {
+			t.Errorf("%s %s: didn't expect error: %v", tc.groupVersion, tc.resource, err)
+		} else if ok != tc.expected {
+			t.Errorf("%s %s: expected %t, got %t", tc.groupVersion, tc.resource, tc.expected, ok)
+		}
+	}
+	for path, n := range calls {
+		if n != 1 {
+			t.Errorf("Expected one discovery request for %s, got %d", path, n)
+		}
+	}
+}
+
+func TestServerSupportsResourceError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "reason": "Forbidden", "code": 403}`)
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	if _, err := c.ServerSupportsResource("batch/v1", "jobs"); err == nil {
+		t.Error("Expected error.")
+	}
+	if _, ok := c.discovery.resources["batch/v1"]; ok {
+		t.Error("Failed discovery shouldn't be cached.")
+	}
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// The api-server speaks a simple multiplexing protocol over WebSockets for
+// exec: every binary message starts with a byte naming its channel.
+const (
+	execProtocolV4 = "v4.channel.k8s.io"
+	execProtocolV5 = "v5.channel.k8s.io"
+
+	execStdin  = 0
+	execStdout = 1
+	execStderr = 2
+	execError  = 3
+	// execClose is only understood by v5. Its payload names the channel
+	// that should be closed.
+	execClose = 255
+
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+
+	// wsMaxFrameSize bounds the memory a single frame can make us allocate.
+	// The api-server sends output in far smaller frames.
+	wsMaxFrameSize = 8 << 20
+
+	// websocketGUID is defined in RFC 6455.
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// Exec runs command in the given container of pod and waits for it to exit.
+// The command's stdout and stderr are copied to stdout and stderr, either of
+// which may be nil to discard them. If stdin is non-nil, it is copied to the
+// command's stdin. Exec returns an error if the command exits non-zero.
+func (c *Client) Exec(pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	c.log("Exec", pod, container, command)
+	if c.fake != nil {
+		return nil
+	}
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	q := url.Values{}
+	q.Set("container", container)
+	q.Set("stdout", "true")
+	q.Set("stderr", "true")
+	if stdin != nil {
+		q.Set("stdin", "true")
+	}
+	for _, arg := range command {
+		q.Add("command", arg)
+	}
+	conn, protocol, err := c.dialExec(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/exec", c.namespace, pod), q)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ws := &wsConn{rw: conn}
+	if stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stdin.Read(buf)
+				if n > 0 {
+					if werr := ws.writeMessage(wsBinary, append([]byte{execStdin}, buf[:n]...)); werr != nil {
+						return
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+			if protocol == execProtocolV5 {
+				ws.writeMessage(wsBinary, []byte{execClose, execStdin})
+			}
+		}()
+	}
+
+	var channel byte
+	for {
+		op, data, err := ws.readFrame()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch op {
+		case wsPing:
+			ws.writeMessage(wsPong, data)
+			continue
+		case wsClose:
+			ws.writeMessage(wsClose, nil)
+			return nil
+		case wsBinary, wsText:
+			if len(data) == 0 {
+				continue
+			}
+			channel, data = data[0], data[1:]
+		case wsContinuation:
+		default:
+			continue
+		}
+		switch channel {
+		case execStdout:
+			if _, err := stdout.Write(data); err != nil {
+				return err
+			}
+		case execStderr:
+			if _, err := stderr.Write(data); err != nil {
+				return err
+			}
+		case execError:
+			return execStatusError(data)
+		}
+	}
+}
+
+// ExecOutput runs command in the given container of pod and returns its
+// stdout. If the command fails, the error includes its stderr.
+func (c *Client) ExecOutput(pod, container string, command []string) (string, error) {
+	var stdout, stderr strings.Builder
+	if err := c.Exec(pod, container, command, nil, &stdout, &stderr); err != nil {
+		return stdout.String(), fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// execStatusError interprets the contents of the error channel, which holds
+// a Status describing how the command exited.
+func execStatusError(data []byte) error {
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("exec failed: %s", string(data))
+	}
+	if status.Status == "Success" {
+		return nil
+	}
+	return StatusError{Code: status.Code, Reason: status.Reason, Message: status.Message}
+}
+
+// dialExec upgrades a request for the exec subresource to a WebSocket and
+// returns the connection along with the negotiated subprotocol.
+func (c *Client) dialExec(path string, query url.Values) (io.ReadWriteCloser, string, error) {
+	u := c.baseURL + path + "?" + query.Encode()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	nonce := base64.StdEncoding.EncodeToString(key)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("User-Agent", c.userAgent())
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", nonce)
+	req.Header.Set("Sec-WebSocket-Protocol", execProtocolV5+", "+execProtocolV4)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		rb, _ := ioutil.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("exec upgrade has status \"%s\" and body \"%s\"", resp.Status, string(rb))
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("exec upgrade response is not writable")
+	}
+	h := sha1.New()
+	io.WriteString(h, nonce+websocketGUID)
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(h.Sum(nil)) {
+		conn.Close()
+		return nil, "", fmt.Errorf("exec upgrade has bad Sec-WebSocket-Accept header")
+	}
+	protocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if protocol != execProtocolV4 && protocol != execProtocolV5 {
+		conn.Close()
+		return nil, "", fmt.Errorf("exec upgrade negotiated unsupported protocol %q", protocol)
+	}
+	return conn, protocol, nil
+}
+
+// wsConn is the bare minimum of a WebSocket client needed to talk to the
+// api-server's exec endpoint.
+type wsConn struct {
+	rw io.ReadWriter
+	// Writes come from both the stdin copier and the read loop.
+	wlock sync.Mutex
+}
+
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(ws.rw, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	op := hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFrameSize {
+		return 0, nil, fmt.Errorf("websocket frame of %d bytes exceeds the limit of %d bytes", n, wsMaxFrameSize)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(ws.rw, data); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	return op, data, nil
+}
+
+// writeMessage sends data as a single masked frame, as clients must.
+func (ws *wsConn) writeMessage(op byte, data []byte) error {
+	frame := []byte{0x80 | op}
+	switch n := len(data); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(append(frame, 0x80|127), ext[:]...)
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range data {
+		frame = append(frame, b^mask[i%4])
+	}
+	ws.wlock.Lock()
+	defer ws.wlock.Unlock()
+	_, err := ws.rw.Write(frame)
+	return err
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// execServer upgrades exec requests and hands the connection to serve.
+func execServer(t *testing.T, protocol string, serve func(ws *wsConn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/ns/pods/po/exec" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("container") != "test" {
+			t.Errorf("Bad container: %s", r.URL.Query().Get("container"))
+		}
+		if cmd := r.URL.Query()["command"]; !reflect.DeepEqual(cmd, []string{"echo", "hi"}) {
+			t.Errorf("Bad command: %v", cmd)
+		}
+		h := sha1.New()
+		io.WriteString(h, r.Header.Get("Sec-WebSocket-Key")+websocketGUID)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Couldn't hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+		rw.WriteString("Connection: Upgrade\r\nUpgrade: websocket\r\n")
+		rw.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h.Sum(nil)) + "\r\n\r\n")
+		rw.Flush()
+		serve(&wsConn{rw: conn})
+	}))
+}
+
+// writeServerFrame writes an unmasked frame, as servers must.
+func writeServerFrame(ws *wsConn, op byte, data []byte) {
+	ws.rw.Write(append([]byte{0x80 | op, byte(len(data))}, data...))
+}
+
+func TestExec(t *testing.T) {
+	var testcases = []struct {
+		name      string
+		status    string
+		expectErr bool
+	}{
+		{
+			name:   "success",
+			status: `{"status": "Success"}`,
+		},
+		{
+			name:      "non-zero exit",
+			status:    `{"status": "Failure", "reason": "NonZeroExitCode", "message": "command terminated with non-zero exit code"}`,
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		ts := execServer(t, execProtocolV4, func(ws *wsConn) {
+			writeServerFrame(ws, wsBinary, append([]byte{execStdout}, "out"...))
+			writeServerFrame(ws, wsBinary, append([]byte{execStderr}, "err"...))
+			writeServerFrame(ws, wsBinary, append([]byte{execError}, tc.status...))
+		})
+		c := getClient(ts.URL)
+		var stdout, stderr bytes.Buffer
+		err := c.Exec("po", "test", []string{"echo", "hi"}, nil, &stdout, &stderr)
+		ts.Close()
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.expectErr, err)
+		}
+		if stdout.String() != "out" {
+			t.Errorf("%s: wrong stdout: %q", tc.name, stdout.String())
+		}
+		if stderr.String() != "err" {
+			t.Errorf("%s: wrong stderr: %q", tc.name, stderr.String())
+		}
+	}
+}
+
+func TestExecStdin(t *testing.T) {
+	ts := execServer(t, execProtocolV5, func(ws *wsConn) {
+		var in []byte
+		for {
+			_, data, err := ws.readFrame()
+			if err != nil {
+				t.Errorf("Couldn't read frame: %v", err)
+				return
+			}
+			if data[0] == execClose {
+				break
+			}
+			in = append(in, data[1:]...)
+		}
+		writeServerFrame(ws, wsBinary, append([]byte{execStdout}, in...))
+		writeServerFrame(ws, wsClose, nil)
+	})
+	defer ts.Close()
+	c := getClient(ts.URL)
+	var stdout bytes.Buffer
+	if err := c.Exec("po", "test", []string{"echo", "hi"}, strings.NewReader("input"), &stdout, nil); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if stdout.String() != "input" {
+		t.Errorf("Wrong stdout: %q", stdout.String())
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	// A binary frame claiming a 64-bit length far above the limit.
+	frame := []byte{0x80 | wsBinary, 127, 0, 0, 0, 1, 0, 0, 0, 0}
+	ws := &wsConn{rw: bytes.NewBuffer(frame)}
+	if _, _, err := ws.readFrame(); err == nil {
+		t.Error("Expected an oversized frame to be rejected.")
+	}
+}
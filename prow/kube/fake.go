@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// FakeResponse is a scripted response to a request made by a fake client.
+type FakeResponse struct {
+	// Body is the JSON response body. If empty, "{}" is used.
+	Body string
+	// If Err is non-nil, the request fails with it instead, for instance
+	// ConflictError{} to simulate a 409.
+	Err error
+}
+
+type fakeResponder struct {
+	sync.Mutex
+	// responses are keyed by "METHOD path".
+	responses map[string][]FakeResponse
+}
+
+// NewFakeClient creates a client that doesn't do anything. Unless scripted
+// with AddFakeResponses, every request succeeds with an empty object.
+func NewFakeClient() *Client {
+	return &Client{
+		namespace: "default",
+		fake:      &fakeResponder{responses: map[string][]FakeResponse{}},
+	}
+}
+
+// AddFakeResponses queues responses for requests that a fake client makes
+// with the given HTTP method and path, such as
+// ("PUT", "/api/v1/namespaces/default/secrets/foo"). Each matching request
+// consumes the next response. Once they run out, requests succeed with an
+// empty object again. It panics if c is not a fake client.
+func (c *Client) AddFakeResponses(method, path string, responses ...FakeResponse) {
+	if c.fake == nil {
+		panic("AddFakeResponses called on a real client")
+	}
+	c.fake.Lock()
+	defer c.fake.Unlock()
+	key := fmt.Sprintf("%s %s", method, path)
+	c.fake.responses[key] = append(c.fake.responses[key], responses...)
+}
+
+func (f *fakeResponder) respond(r *request) (io.ReadCloser, error) {
+	f.Lock()
+	defer f.Unlock()
+	key := fmt.Sprintf("%s %s", r.method, r.path)
+	body := "{}"
+	if rs := f.responses[key]; len(rs) > 0 {
+		f.responses[key] = rs[1:]
+		if rs[0].Err != nil {
+			return nil, rs[0].Err
+		}
+		if rs[0].Body != "" {
+			body = rs[0].Body
+		}
+	}
+	return ioutil.NopCloser(strings.NewReader(body)), nil
+}
@@ -0,0 +1,381 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClientDefault(t *testing.T) {
+	c := NewFakeClient()
+	if _, err := c.GetPod("po"); err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+}
+
+func TestFakeClientResponses(t *testing.T) {
+	c := NewFakeClient()
+	c.AddFakeResponses(http.MethodGet, "/api/v1/namespaces/default/pods/po",
+		FakeResponse{Body: `{"status": {"phase": "Pending"}}`},
+		FakeResponse{Err: fmt.Errorf("injected")},
+	)
+	po, err := c.GetPod("po")
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if po.Status.Phase != PodPending {
+		t.Errorf("Wrong phase: %s", po.Status.Phase)
+	}
+	if _, err := c.GetPod("po"); err == nil || err.Error() != "injected" {
+		t.Errorf("Expected injected error, got %v", err)
+	}
+	if _, err := c.GetPod("po"); err != nil {
+		t.Errorf("Expected success once responses run out, got %v", err)
+	}
+}
+
+// TestFakeClientConflict shows how to exercise a read-modify-write loop that
+// hits a conflict on its first write.
+func TestFakeClientConflict(t *testing.T) {
+	c := NewFakeClient()
+	c.AddFakeResponses(http.MethodPut, "/api/v1/namespaces/default/secrets/token",
+		FakeResponse{Err: ConflictError{Body: "the object has been modified"}},
+	)
+	attempts := 0
+	err := RetryOnConflict(func() error {
+		attempts++
+		return c.ReplaceSecret("token", Secret{Data: map[string]string{"token": "new"}})
+	})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected two attempts, got %d", attempts)
+	}
+}
+
+func TestRunJobAndGetPod(t *testing.T) {
+	var testcases = []struct {
+		name      string
+		podPhases []string
+		expectErr bool
+	}{
+		{
+			name:      "pod starts running",
+			podPhases: []string{"Pending", "Running"},
+		},
+		{
+			name:      "pod already succeeded",
+			podPhases: []string{"Succeeded"},
+		},
+		{
+			name:      "pod failed",
+			podPhases: []string{"Pending", "Failed"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		c := NewFakeClient()
+		c.PollInterval = time.Millisecond
+		c.AddFakeResponses(http.MethodPost, "/apis/batch/v1/namespaces/default/jobs",
+			FakeResponse{Body: `{"metadata": {"name": "diag"}}`})
+		c.AddFakeResponses(http.MethodGet, "/apis/batch/v1/namespaces/default/jobs/diag",
+			FakeResponse{Body: `{"metadata": {"name": "diag"}}`},
+			FakeResponse{Body: `{"metadata": {"name": "diag"}}`})
+		c.AddFakeResponses(http.MethodGet, "/api/v1/namespaces/default/pods",
+			FakeResponse{Body: `{"items": []}`},
+			FakeResponse{Body: `{"items": [{"metadata": {"name": "diag-abcde"}}]}`})
+		for _, phase := range tc.podPhases {
+			c.AddFakeResponses(http.MethodGet, "/api/v1/namespaces/default/pods/diag-abcde",
+				FakeResponse{Body: fmt.Sprintf(`{"metadata": {"name": "diag-abcde"}, "status": {"phase": "%s"}}`, phase)})
+		}
+		job, pod, err := c.RunJobAndGetPod(context.Background(), Job{})
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.expectErr, err)
+		}
+		if job.Metadata.Name != "diag" {
+			t.Errorf("%s: wrong job: %s", tc.name, job.Metadata.Name)
+		}
+		if pod.Metadata.Name != "diag-abcde" {
+			t.Errorf("%s: wrong pod: %s", tc.name, pod.Metadata.Name)
+		}
+	}
+}
+
+func TestRunJobAndGetPodTimeout(t *testing.T) {
+	c := NewFakeClient()
+	c.PollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// The fake job never creates any pods.
+	if _, _, err := c.RunJobAndGetPod(ctx, Job{}); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestUpdateJobStatusWithRetry(t *testing.T) {
+	c := NewFakeClient()
+	path := "/apis/batch/v1/namespaces/default/jobs/jo"
+	c.AddFakeResponses(http.MethodGet, path,
+		FakeResponse{Body: `{"metadata": {"resourceVersion": "1"}, "status": {"active": 1}}`},
+		FakeResponse{Body: `{"metadata": {"resourceVersion": "2"}, "status": {"active": 2}}`},
+	)
+	c.AddFakeResponses(http.MethodPatch, path+"/status",
+		FakeResponse{Err: ConflictError{Body: "the object has been modified"}},
+		FakeResponse{Body: `{"metadata": {"resourceVersion": "3"}, "status": {"active": 2, "failed": 1}}`},
+	)
+	var seen []string
+	job, err := c.UpdateJobStatusWithRetry("jo", func(j *Job) {
+		seen = append(seen, j.Metadata.ResourceVersion)
+		j.Status.Failed++
+	})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if len(seen) != 2 || seen[1] != "2" {
+		t.Errorf("Expected the job to be reread after the conflict, saw versions %v", seen)
+	}
+	if job.Status.Failed != 1 || job.Metadata.ResourceVersion != "3" {
+		t.Errorf("Wrong job returned: %+v", job)
+	}
+}
+
+func TestGetSingletonPod(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		list     string
+		expected string
+		err      error
+	}{
+		{
+			name: "none",
+			list: `{"items": []}`,
+			err:  NoMatchingPodError{Selector: "job-name = jo"},
+		},
+		{
+			name:     "one",
+			list:     `{"items": [{"metadata": {"name": "a"}}]}`,
+			expected: "a",
+		},
+		{
+			name: "many",
+			list: `{"items": [{"metadata": {"name": "a"}}, {"metadata": {"name": "b"}}]}`,
+			err:  MultipleMatchingPodsError{Selector: "job-name = jo", Count: 2},
+		},
+	}
+	for _, tc := range testcases {
+		c := NewFakeClient()
+		c.AddFakeResponses(http.MethodGet, "/api/v1/namespaces/default/pods", FakeResponse{Body: tc.list})
+		pod, err := c.GetSingletonPod(map[string]string{"job-name": "jo"})
+		if err != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+		}
+		if pod.Metadata.Name != tc.expected {
+			t.Errorf("%s: expected pod %q, got %q", tc.name, tc.expected, pod.Metadata.Name)
+		}
+	}
+}
+
+func TestPatchOrCreateJob(t *testing.T) {
+	var testcases = []struct {
+		name          string
+		patchResponse FakeResponse
+		expectCreate  bool
+	}{
+		{
+			name:          "patched",
+			patchResponse: FakeResponse{Body: `{"metadata": {"name": "jo", "labels": {"patched": "true"}}}`},
+		},
+		{
+			name:          "created",
+			patchResponse: FakeResponse{Err: StatusError{Code: http.StatusNotFound, Reason: "NotFound"}},
+			expectCreate:  true,
+		},
+	}
+	for _, tc := range testcases {
+		c := NewFakeClient()
+		c.AddFakeResponses(http.MethodPatch, "/apis/batch/v1/namespaces/default/jobs/jo", tc.patchResponse)
+		c.AddFakeResponses(http.MethodPost, "/apis/batch/v1/namespaces/default/jobs",
+			FakeResponse{Body: `{"metadata": {"name": "jo", "labels": {"created": "true"}}}`},
+		)
+		job, err := c.PatchOrCreateJob("jo", Job{})
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+			continue
+		}
+		if created := job.Metadata.Labels["created"] == "true"; created != tc.expectCreate {
+			t.Errorf("%s: expected create %t, got job %+v", tc.name, tc.expectCreate, job)
+		}
+	}
+}
+
+func TestPatchOrCreateJobError(t *testing.T) {
+	c := NewFakeClient()
+	c.AddFakeResponses(http.MethodPatch, "/apis/batch/v1/namespaces/default/jobs/jo",
+		FakeResponse{Err: StatusError{Code: http.StatusUnprocessableEntity, Reason: "Invalid"}},
+	)
+	if _, err := c.PatchOrCreateJob("jo", Job{}); err == nil {
+		t.Error("Expected a failed patch other than a 404 to be returned.")
+	}
+}
+
+func TestCreateJobIgnoreAlreadyExists(t *testing.T) {
+	var testcases = []struct {
+		name      string
+		opts      CreateOptions
+		expectErr bool
+	}{
+		{
+			name: "existing job returned",
+			opts: CreateOptions{IgnoreAlreadyExists: true},
+		},
+		{
+			name:      "already exists by default",
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		c := NewFakeClient()
+		c.AddFakeResponses(http.MethodPost, "/apis/batch/v1/namespaces/default/jobs",
+			FakeResponse{Err: AlreadyExistsError{Body: `jobs.batch "jo" already exists`}},
+		)
+		c.AddFakeResponses(http.MethodGet, "/apis/batch/v1/namespaces/default/jobs/jo",
+			FakeResponse{Body: `{"metadata": {"name": "jo", "uid": "existing"}}`},
+		)
+		job, err := c.CreateJobWithOptions(Job{Metadata: ObjectMeta{Name: "jo"}}, tc.opts)
+		if tc.expectErr {
+			if !IsAlreadyExists(err) {
+				t.Errorf("%s: expected AlreadyExistsError, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+		} else if job.Metadata.UID != "existing" {
+			t.Errorf("%s: expected the existing job, got %+v", tc.name, job)
+		}
+	}
+}
+
+func TestCreatePodIgnoreAlreadyExists(t *testing.T) {
+	c := NewFakeClient()
+	c.AddFakeResponses(http.MethodPost, "/api/v1/namespaces/default/pods",
+		FakeResponse{Err: AlreadyExistsError{Body: `pods "po" already exists`}},
+	)
+	c.AddFakeResponses(http.MethodGet, "/api/v1/namespaces/default/pods/po",
+		FakeResponse{Body: `{"metadata": {"name": "po", "uid": "existing"}}`},
+	)
+	pod, err := c.CreatePodWithOptions(Pod{Metadata: ObjectMeta{Name: "po"}}, CreateOptions{IgnoreAlreadyExists: true})
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	} else if pod.Metadata.UID != "existing" {
+		t.Errorf("Expected the existing pod, got %+v", pod)
+	}
+}
+
+func TestListJobsByCompletion(t *testing.T) {
+	jobs := FakeResponse{Body: `{"items": [
+		{"metadata": {"name": "running"}, "status": {"active": 1}},
+		{"metadata": {"name": "succeeded"}, "status": {"succeeded": 1}},
+		{"metadata": {"name": "failed"}, "status": {"failed": 7, "conditions": [{"type": "Failed", "status": "True", "reason": "BackoffLimitExceeded"}]}},
+		{"metadata": {"name": "retrying"}, "status": {"failed": 1}}
+	]}`}
+	c := NewFakeClient()
+	c.AddFakeResponses(http.MethodGet, "/apis/batch/v1/namespaces/default/jobs", jobs, jobs)
+	names := func(jobs []Job) []string {
+		var ns []string
+		for _, j := range jobs {
+			ns = append(ns, j.Metadata.Name)
+		}
+		return ns
+	}
+	active, err := c.ListActiveJobs(nil)
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if got := names(active); !reflect.DeepEqual(got, []string{"running", "retrying"}) {
+		t.Errorf("Wrong active jobs: %v", got)
+	}
+	completed, err := c.ListCompletedJobs(nil)
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if got := names(completed); !reflect.DeepEqual(got, []string{"succeeded", "failed"}) {
+		t.Errorf("Wrong completed jobs: %v", got)
+	}
+}
+
+func TestWaitForSecret(t *testing.T) {
+	notFound := FakeResponse{Err: StatusError{Code: http.StatusNotFound, Reason: "NotFound"}}
+	c := NewFakeClient()
+	c.PollInterval = time.Millisecond
+	c.AddFakeResponses(http.MethodGet, "/api/v1/namespaces/default/secrets/token",
+		notFound,
+		notFound,
+		FakeResponse{Body: `{"metadata": {"name": "token"}, "data": {"token": "c2VjcmV0"}}`},
+	)
+	secret, err := c.WaitForSecret(context.Background(), "token")
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if secret.Data["token"] != "c2VjcmV0" {
+		t.Errorf("Wrong secret: %+v", secret)
+	}
+}
+
+func TestWaitForSecretTimeout(t *testing.T) {
+	c := NewFakeClient()
+	c.PollInterval = time.Millisecond
+	for i := 0; i < 100; i++ {
+		c.AddFakeResponses(http.MethodGet, "/api/v1/namespaces/default/secrets/token",
+			FakeResponse{Err: StatusError{Code: http.StatusNotFound, Reason: "NotFound"}},
+		)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitForSecret(ctx, "token"); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestListJobsSince(t *testing.T) {
+	c := NewFakeClient()
+	c.AddFakeResponses(http.MethodGet, "/apis/batch/v1/namespaces/default/jobs",
+		FakeResponse{Body: `{"items": [
+			{"metadata": {"name": "old", "creationTimestamp": "2017-06-01T10:59:59Z"}},
+			{"metadata": {"name": "exact", "creationTimestamp": "2017-06-01T11:00:00Z"}},
+			{"metadata": {"name": "new", "creationTimestamp": "2017-06-01T11:30:00Z"}},
+			{"metadata": {"name": "unknown"}}
+		]}`},
+	)
+	jobs, err := c.ListJobsSince(time.Date(2017, 6, 1, 11, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	var names []string
+	for _, j := range jobs {
+		names = append(names, j.Metadata.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"exact", "new"}) {
+		t.Errorf("Wrong jobs: %v", names)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// quantitySuffixes maps the suffixes of Kubernetes resource quantities, such
+// as the "Gi" of "16Gi", to their multipliers.
+var quantitySuffixes = map[string]*big.Rat{
+	"n":  big.NewRat(1, 1000000000),
+	"u":  big.NewRat(1, 1000000),
+	"m":  big.NewRat(1, 1000),
+	"":   big.NewRat(1, 1),
+	"k":  big.NewRat(1000, 1),
+	"M":  new(big.Rat).SetInt64(1e6),
+	"G":  new(big.Rat).SetInt64(1e9),
+	"T":  new(big.Rat).SetInt64(1e12),
+	"P":  new(big.Rat).SetInt64(1e15),
+	"E":  new(big.Rat).SetInt64(1e18),
+	"Ki": new(big.Rat).SetInt64(1 << 10),
+	"Mi": new(big.Rat).SetInt64(1 << 20),
+	"Gi": new(big.Rat).SetInt64(1 << 30),
+	"Ti": new(big.Rat).SetInt64(1 << 40),
+	"Pi": new(big.Rat).SetInt64(1 << 50),
+	"Ei": new(big.Rat).SetInt64(1 << 60),
+}
+
+// parseQuantity parses a resource quantity such as "500m", "1.5", "16Gi", or
+// "1e3" and returns it in units of 1/scale, rounded up as the api-server
+// does. An empty quantity is zero.
+func parseQuantity(s string, scale int64) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.+-", r)
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	number, suffix := s[:i], s[i:]
+	n, ok := new(big.Rat).SetString(number)
+	if !ok || number == "" {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	multiplier, ok := quantitySuffixes[suffix]
+	if !ok {
+		// A decimal exponent, as in "1e3".
+		exp, err := strconv.Atoi(suffix[1:])
+		if (suffix[0] != 'e' && suffix[0] != 'E') || err != nil || exp < -18 || exp > 18 {
+			return 0, fmt.Errorf("invalid quantity %q", s)
+		}
+		if exp < 0 {
+			multiplier = new(big.Rat).SetFrac(big.NewInt(1), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-exp)), nil))
+		} else {
+			multiplier = new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil))
+		}
+	}
+	n.Mul(n, multiplier)
+	n.Mul(n, new(big.Rat).SetInt64(scale))
+	// Round up to a whole number of units.
+	q, r := new(big.Int).QuoRem(n.Num(), n.Denom(), new(big.Int))
+	if r.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("quantity %q is too large", s)
+	}
+	return q.Int64(), nil
+}
+
+// TotalResourceRequests returns the CPU, in millicores, and memory, in
+// bytes, that the pod requests. As when the scheduler places the pod, init
+// containers run one at a time before the others, so each resource is the
+// larger of the largest init container's request and the sum of the other
+// containers' requests.
+func (p *Pod) TotalResourceRequests() (cpuMillis, memoryBytes int64, err error) {
+	return p.totalResources(func(r Resources) *ResourceRequest { return r.Requests })
+}
+
+// TotalResourceLimits is like TotalResourceRequests for the pod's limits.
+// Containers without a limit don't add to the total.
+func (p *Pod) TotalResourceLimits() (cpuMillis, memoryBytes int64, err error) {
+	return p.totalResources(func(r Resources) *ResourceRequest { return r.Limits })
+}
+
+func (p *Pod) totalResources(get func(Resources) *ResourceRequest) (cpuMillis, memoryBytes int64, err error) {
+	quantities := func(c Container) (int64, int64, error) {
+		rr := get(c.Resources)
+		if rr == nil {
+			return 0, 0, nil
+		}
+		cpu, err := parseQuantity(rr.CPU, 1000)
+		if err != nil {
+			return 0, 0, fmt.Errorf("container %s cpu: %v", c.Name, err)
+		}
+		memory, err := parseQuantity(rr.Memory, 1)
+		if err != nil {
+			return 0, 0, fmt.Errorf("container %s memory: %v", c.Name, err)
+		}
+		return cpu, memory, nil
+	}
+	for _, c := range p.Spec.Containers {
+		cpu, memory, err := quantities(c)
+		if err != nil {
+			return 0, 0, err
+		}
+		cpuMillis += cpu
+		memoryBytes += memory
+	}
+	for _, c := range p.Spec.InitContainers {
+		cpu, memory, err := quantities(c)
+		if err != nil {
+			return 0, 0, err
+		}
+		if cpu > cpuMillis {
+			cpuMillis = cpu
+		}
+		if memory > memoryBytes {
+			memoryBytes = memory
+		}
+	}
+	return cpuMillis, memoryBytes, nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	var testcases = []struct {
+		quantity  string
+		scale     int64
+		expected  int64
+		expectErr bool
+	}{
+		{quantity: "", scale: 1000, expected: 0},
+		{quantity: "2", scale: 1000, expected: 2000},
+		{quantity: "500m", scale: 1000, expected: 500},
+		{quantity: "1.5", scale: 1000, expected: 1500},
+		{quantity: "0.1", scale: 1000, expected: 100},
+		{quantity: "100u", scale: 1000, expected: 1},
+		{quantity: "128974848", scale: 1, expected: 128974848},
+		{quantity: "129e6", scale: 1, expected: 129000000},
+		{quantity: "129M", scale: 1, expected: 129000000},
+		{quantity: "123Mi", scale: 1, expected: 123 << 20},
+		{quantity: "1.5Gi", scale: 1, expected: 3 << 29},
+		{quantity: "1k", scale: 1, expected: 1000},
+		{quantity: "1Ki", scale: 1, expected: 1024},
+		{quantity: "10x", scale: 1, expectErr: true},
+		{quantity: "Gi", scale: 1, expectErr: true},
+		{quantity: "1.2.3", scale: 1, expectErr: true},
+		{quantity: "100Ei", scale: 1, expectErr: true},
+	}
+	for _, tc := range testcases {
+		n, err := parseQuantity(tc.quantity, tc.scale)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", tc.quantity, n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: didn't expect error: %v", tc.quantity, err)
+		} else if n != tc.expected {
+			t.Errorf("%q: expected %d, got %d", tc.quantity, tc.expected, n)
+		}
+	}
+}
+
+func TestTotalResourceRequests(t *testing.T) {
+	container := func(cpu, memory string) Container {
+		return Container{Resources: Resources{Requests: &ResourceRequest{CPU: cpu, Memory: memory}}}
+	}
+	var testcases = []struct {
+		name           string
+		initContainers []Container
+		containers     []Container
+		expectedCPU    int64
+		expectedMemory int64
+	}{
+		{
+			name:           "containers are summed",
+			containers:     []Container{container("500m", "1Gi"), container("250m", "512Mi")},
+			expectedCPU:    750,
+			expectedMemory: 3 << 29,
+		},
+		{
+			name:        "containers without requests add nothing",
+			containers:  []Container{container("1", ""), {}},
+			expectedCPU: 1000,
+		},
+		{
+			name:           "larger init container wins",
+			initContainers: []Container{container("2", "256Mi"), container("100m", "4Gi")},
+			containers:     []Container{container("500m", "1Gi"), container("500m", "1Gi")},
+			expectedCPU:    2000,
+			expectedMemory: 4 << 30,
+		},
+		{
+			name:           "init containers are not summed",
+			initContainers: []Container{container("600m", "1Gi"), container("600m", "1Gi")},
+			containers:     []Container{container("1", "1536Mi")},
+			expectedCPU:    1000,
+			expectedMemory: 3 << 29,
+		},
+	}
+	for _, tc := range testcases {
+		p := Pod{Spec: PodSpec{InitContainers: tc.initContainers, Containers: tc.containers}}
+		cpu, memory, err := p.TotalResourceRequests()
+		if err != nil {
+			t.Errorf("%s: didn't expect error: %v", tc.name, err)
+			continue
+		}
+		if cpu != tc.expectedCPU || memory != tc.expectedMemory {
+			t.Errorf("%s: expected %dm and %d bytes, got %dm and %d bytes", tc.name, tc.expectedCPU, tc.expectedMemory, cpu, memory)
+		}
+	}
+}
+
+func TestTotalResourceLimits(t *testing.T) {
+	p := Pod{Spec: PodSpec{
+		InitContainers: []Container{{Resources: Resources{Limits: &ResourceRequest{CPU: "3"}}}},
+		Containers: []Container{
+			{Resources: Resources{Requests: &ResourceRequest{CPU: "8", Memory: "8Gi"}, Limits: &ResourceRequest{CPU: "1", Memory: "2Gi"}}},
+			{Resources: Resources{Limits: &ResourceRequest{CPU: "1", Memory: "2Gi"}}},
+		},
+	}}
+	cpu, memory, err := p.TotalResourceLimits()
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	if cpu != 3000 || memory != 4<<30 {
+		t.Errorf("Expected 3000m and %d bytes, got %dm and %d bytes", 4<<30, cpu, memory)
+	}
+
+	p.Spec.Containers[1].Name = "bad"
+	p.Spec.Containers[1].Resources.Limits.Memory = "lots"
+	if _, _, err := p.TotalResourceLimits(); err == nil {
+		t.Error("Expected an error for a malformed quantity.")
+	}
+}
@@ -21,13 +21,143 @@ import (
 )
 
 type ObjectMeta struct {
-	Name        string            `json:"name,omitempty"`
-	Namespace   string            `json:"namespace,omitempty"`
-	Labels      map[string]string `json:"labels,omitempty"`
-	Annotations map[string]string `json:"annotations,omitempty"`
+	Name string `json:"name,omitempty"`
+	// GenerateName asks the api-server to name the object by appending a
+	// random suffix to it. It is ignored if Name is set, so set only one.
+	GenerateName string            `json:"generateName,omitempty"`
+	Namespace    string            `json:"namespace,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+
+	ResourceVersion string           `json:"resourceVersion,omitempty"`
+	UID             string           `json:"uid,omitempty"`
+	OwnerReferences []OwnerReference `json:"ownerReferences,omitempty"`
+
+	// CreationTimestamp is set by the api-server when the object is created.
+	CreationTimestamp *time.Time `json:"creationTimestamp,omitempty"`
+	// DeletionTimestamp is set once the object is being gracefully deleted.
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+}
 
+// ListMeta is the metadata of a list response.
+type ListMeta struct {
 	ResourceVersion string `json:"resourceVersion,omitempty"`
-	UID             string `json:"uid,omitempty"`
+	Continue        string `json:"continue,omitempty"`
+}
+
+// ListOptions narrows and pages a list request. Unset fields are omitted.
+type ListOptions struct {
+	// LabelSelector is a selector such as "app = deck,tier != canary".
+	LabelSelector string
+	// FieldSelector is a selector such as "status.phase=Running".
+	FieldSelector string
+	// Limit caps the number of items returned. If more remain, the list's
+	// Continue token fetches the next page.
+	Limit int64
+	// Continue is the token from the previous page.
+	Continue string
+	// ResourceVersion requests the list as of that version.
+	ResourceVersion string
+	// TimeoutSeconds bounds the request on the api-server's side.
+	TimeoutSeconds int64
+	// SendInitialEvents makes a watch stream the current state before the
+	// changes to it, rather than listing it first. Lists ignore it.
+	SendInitialEvents bool
+}
+
+// GetOptions tunes a get request. Unset fields are omitted.
+type GetOptions struct {
+	// ResourceVersion "0" lets the api-server answer from its watch cache,
+	// which is much cheaper than a read from etcd but may be stale.
+	ResourceVersion string
+}
+
+// CreateOptions tunes a create request.
+type CreateOptions struct {
+	// IgnoreAlreadyExists makes creating an object that already exists
+	// return the existing object rather than an AlreadyExistsError.
+	IgnoreAlreadyExists bool
+}
+
+type PodList struct {
+	Metadata ListMeta `json:"metadata,omitempty"`
+	Items    []Pod    `json:"items"`
+}
+
+type JobList struct {
+	Metadata ListMeta `json:"metadata,omitempty"`
+	Items    []Job    `json:"items"`
+}
+
+type OwnerReference struct {
+	APIVersion         string `json:"apiVersion"`
+	Kind               string `json:"kind"`
+	Name               string `json:"name"`
+	UID                string `json:"uid"`
+	Controller         *bool  `json:"controller,omitempty"`
+	BlockOwnerDeletion *bool  `json:"blockOwnerDeletion,omitempty"`
+}
+
+// Status is the api-server's description of a failed request.
+type Status struct {
+	Kind    string `json:"kind,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+// Event records something that happened to another object, such as a Job
+// failing to create pods.
+type Event struct {
+	Metadata       ObjectMeta      `json:"metadata,omitempty"`
+	InvolvedObject ObjectReference `json:"involvedObject,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+	Message        string          `json:"message,omitempty"`
+	Type           string          `json:"type,omitempty"`
+	Count          int             `json:"count,omitempty"`
+	FirstTimestamp time.Time       `json:"firstTimestamp,omitempty"`
+	LastTimestamp  time.Time       `json:"lastTimestamp,omitempty"`
+}
+
+type ObjectReference struct {
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	UID       string `json:"uid,omitempty"`
+}
+
+// Eviction asks the api-server to delete a pod, subject to its disruption
+// budgets.
+type Eviction struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   ObjectMeta `json:"metadata,omitempty"`
+}
+
+// SelfSubjectAccessReview asks whether the client may perform an action.
+type SelfSubjectAccessReview struct {
+	APIVersion string                      `json:"apiVersion,omitempty"`
+	Kind       string                      `json:"kind,omitempty"`
+	Spec       SelfSubjectAccessReviewSpec `json:"spec"`
+	Status     SubjectAccessReviewStatus   `json:"status,omitempty"`
+}
+
+type SelfSubjectAccessReviewSpec struct {
+	ResourceAttributes *ResourceAttributes `json:"resourceAttributes,omitempty"`
+}
+
+type ResourceAttributes struct {
+	Namespace string `json:"namespace,omitempty"`
+	Verb      string `json:"verb,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource,omitempty"`
+}
+
+type SubjectAccessReviewStatus struct {
+	Allowed bool   `json:"allowed"`
+	Denied  bool   `json:"denied,omitempty"`
+	Reason  string `json:"reason,omitempty"`
 }
 
 type Secret struct {
@@ -35,6 +165,89 @@ type Secret struct {
 	Data     map[string]string `json:"data,omitempty"`
 }
 
+type ConfigMap struct {
+	Metadata ObjectMeta        `json:"metadata,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+type Namespace struct {
+	Metadata ObjectMeta      `json:"metadata,omitempty"`
+	Status   NamespaceStatus `json:"status,omitempty"`
+}
+
+type NamespaceStatus struct {
+	Phase string `json:"phase,omitempty"`
+}
+
+// ResourceQuota limits the total resources used by a namespace. Quantities
+// are kept as strings such as "4" or "16Gi".
+type ResourceQuota struct {
+	Metadata ObjectMeta          `json:"metadata,omitempty"`
+	Spec     ResourceQuotaSpec   `json:"spec,omitempty"`
+	Status   ResourceQuotaStatus `json:"status,omitempty"`
+}
+
+type ResourceQuotaSpec struct {
+	Hard map[string]string `json:"hard,omitempty"`
+}
+
+type ResourceQuotaStatus struct {
+	Hard map[string]string `json:"hard,omitempty"`
+	Used map[string]string `json:"used,omitempty"`
+}
+
+// PersistentVolumeClaim requests storage. Quantities are kept as strings
+// such as "10Gi".
+type PersistentVolumeClaim struct {
+	Metadata ObjectMeta                  `json:"metadata,omitempty"`
+	Spec     PersistentVolumeClaimSpec   `json:"spec,omitempty"`
+	Status   PersistentVolumeClaimStatus `json:"status,omitempty"`
+}
+
+type PersistentVolumeClaimSpec struct {
+	AccessModes []string `json:"accessModes,omitempty"`
+	// StorageClassName is omitted to use the cluster's default class.
+	StorageClassName string                 `json:"storageClassName,omitempty"`
+	Resources        VolumeResourceRequests `json:"resources,omitempty"`
+}
+
+type VolumeResourceRequests struct {
+	Requests map[string]string `json:"requests,omitempty"`
+}
+
+type PersistentVolumeClaimPhase string
+
+const (
+	ClaimPending PersistentVolumeClaimPhase = "Pending"
+	ClaimBound   PersistentVolumeClaimPhase = "Bound"
+	ClaimLost    PersistentVolumeClaimPhase = "Lost"
+)
+
+type PersistentVolumeClaimStatus struct {
+	Phase PersistentVolumeClaimPhase `json:"phase,omitempty"`
+}
+
+// LimitRange constrains the resources of objects in a namespace and supplies
+// defaults for containers that don't request any.
+type LimitRange struct {
+	Metadata ObjectMeta     `json:"metadata,omitempty"`
+	Spec     LimitRangeSpec `json:"spec,omitempty"`
+}
+
+type LimitRangeSpec struct {
+	Limits []LimitRangeItem `json:"limits,omitempty"`
+}
+
+// LimitRangeItem holds the limits for one Type of object, such as
+// "Container" or "Pod".
+type LimitRangeItem struct {
+	Type           string            `json:"type,omitempty"`
+	Max            map[string]string `json:"max,omitempty"`
+	Min            map[string]string `json:"min,omitempty"`
+	Default        map[string]string `json:"default,omitempty"`
+	DefaultRequest map[string]string `json:"defaultRequest,omitempty"`
+}
+
 type Job struct {
 	Metadata ObjectMeta `json:"metadata,omitempty"`
 	Spec     JobSpec    `json:"spec,omitempty"`
@@ -52,20 +265,145 @@ func (j *Job) Complete() bool {
 	return false
 }
 
+// Failed reports whether the job has a true Failed condition, which the job
+// controller sets once the job has exhausted its retries or deadline.
+func (j *Job) Failed() bool {
+	for _, c := range j.Status.Conditions {
+		if c.Type == JobFailed && c.Status == "True" {
+			return true
+		}
+	}
+	return false
+}
+
 type JobSpec struct {
 	Completions           *int `json:"completions,omitempty"`
 	Parallelism           *int `json:"parallelism,omitempty"`
 	ActiveDeadlineSeconds int  `json:"activeDeadlineSeconds,omitempty"`
+	// BackoffLimit is how many times a failed pod is retried. The
+	// api-server defaults it to 6.
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
 
+	Selector *LabelSelector  `json:"selector,omitempty"`
 	Template PodTemplateSpec `json:"template,omitempty"`
 }
 
+type LabelSelector struct {
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
 type JobStatus struct {
 	StartTime      time.Time `json:"startTime,omitempty"`
 	CompletionTime time.Time `json:"completionTime,omitempty"`
 	Active         int       `json:"active,omitempty"`
 	Succeeded      int       `json:"succeeded,omitempty"`
 	Failed         int       `json:"failed,omitempty"`
+
+	Conditions []JobCondition `json:"conditions,omitempty"`
+}
+
+type JobConditionType string
+
+const (
+	JobComplete JobConditionType = "Complete"
+	JobFailed   JobConditionType = "Failed"
+)
+
+type JobCondition struct {
+	Type               JobConditionType `json:"type"`
+	Status             string           `json:"status"`
+	LastProbeTime      time.Time        `json:"lastProbeTime,omitempty"`
+	LastTransitionTime time.Time        `json:"lastTransitionTime,omitempty"`
+	Reason             string           `json:"reason,omitempty"`
+	Message            string           `json:"message,omitempty"`
+}
+
+type CronJob struct {
+	Metadata ObjectMeta    `json:"metadata,omitempty"`
+	Spec     CronJobSpec   `json:"spec,omitempty"`
+	Status   CronJobStatus `json:"status,omitempty"`
+}
+
+type CronJobSpec struct {
+	Schedule    string          `json:"schedule,omitempty"`
+	Suspend     *bool           `json:"suspend,omitempty"`
+	JobTemplate JobTemplateSpec `json:"jobTemplate,omitempty"`
+}
+
+type CronJobStatus struct {
+	LastScheduleTime time.Time `json:"lastScheduleTime,omitempty"`
+}
+
+type JobTemplateSpec struct {
+	Metadata ObjectMeta `json:"metadata,omitempty"`
+	Spec     JobSpec    `json:"spec,omitempty"`
+}
+
+type Deployment struct {
+	Metadata ObjectMeta       `json:"metadata,omitempty"`
+	Spec     DeploymentSpec   `json:"spec,omitempty"`
+	Status   DeploymentStatus `json:"status,omitempty"`
+}
+
+type DeploymentSpec struct {
+	Replicas *int32          `json:"replicas,omitempty"`
+	Template PodTemplateSpec `json:"template,omitempty"`
+}
+
+type DeploymentStatus struct {
+	Replicas          int32 `json:"replicas,omitempty"`
+	UpdatedReplicas   int32 `json:"updatedReplicas,omitempty"`
+	ReadyReplicas     int32 `json:"readyReplicas,omitempty"`
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+}
+
+type StatefulSet struct {
+	Metadata ObjectMeta        `json:"metadata,omitempty"`
+	Spec     StatefulSetSpec   `json:"spec,omitempty"`
+	Status   StatefulSetStatus `json:"status,omitempty"`
+}
+
+type StatefulSetSpec struct {
+	Replicas    *int32          `json:"replicas,omitempty"`
+	ServiceName string          `json:"serviceName,omitempty"`
+	Template    PodTemplateSpec `json:"template,omitempty"`
+}
+
+type StatefulSetStatus struct {
+	Replicas        int32  `json:"replicas,omitempty"`
+	ReadyReplicas   int32  `json:"readyReplicas,omitempty"`
+	CurrentReplicas int32  `json:"currentReplicas,omitempty"`
+	UpdatedReplicas int32  `json:"updatedReplicas,omitempty"`
+	CurrentRevision string `json:"currentRevision,omitempty"`
+	UpdateRevision  string `json:"updateRevision,omitempty"`
+}
+
+type DaemonSet struct {
+	Metadata ObjectMeta      `json:"metadata,omitempty"`
+	Spec     DaemonSetSpec   `json:"spec,omitempty"`
+	Status   DaemonSetStatus `json:"status,omitempty"`
+}
+
+type DaemonSetSpec struct {
+	Template PodTemplateSpec `json:"template,omitempty"`
+}
+
+type DaemonSetStatus struct {
+	DesiredNumberScheduled int32 `json:"desiredNumberScheduled,omitempty"`
+	CurrentNumberScheduled int32 `json:"currentNumberScheduled,omitempty"`
+	NumberReady            int32 `json:"numberReady,omitempty"`
+	NumberAvailable        int32 `json:"numberAvailable,omitempty"`
+	UpdatedNumberScheduled int32 `json:"updatedNumberScheduled,omitempty"`
+}
+
+// Scale is the body of the scale subresource.
+type Scale struct {
+	Metadata ObjectMeta `json:"metadata,omitempty"`
+	Spec     ScaleSpec  `json:"spec,omitempty"`
+}
+
+type ScaleSpec struct {
+	Replicas int32 `json:"replicas"`
 }
 
 type PodTemplateSpec struct {
@@ -80,10 +418,12 @@ type Pod struct {
 }
 
 type PodSpec struct {
-	Volumes       []Volume          `json:"volumes,omitempty"`
-	Containers    []Container       `json:"containers,omitempty"`
-	RestartPolicy string            `json:"restartPolicy,omitempty"`
-	NodeSelector  map[string]string `json:"nodeSelector,omitempty"`
+	Volumes        []Volume          `json:"volumes,omitempty"`
+	InitContainers []Container       `json:"initContainers,omitempty"`
+	Containers     []Container       `json:"containers,omitempty"`
+	RestartPolicy  string            `json:"restartPolicy,omitempty"`
+	NodeSelector   map[string]string `json:"nodeSelector,omitempty"`
+	NodeName       string            `json:"nodeName,omitempty"`
 }
 
 type PodPhase string
@@ -101,6 +441,103 @@ type PodStatus struct {
 	Message   string    `json:"message,omitempty"`
 	Reason    string    `json:"reason,omitempty"`
 	StartTime time.Time `json:"startTime,omitempty"`
+
+	Conditions        []PodCondition    `json:"conditions,omitempty"`
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+type PodCondition struct {
+	Type   string `json:"type,omitempty"`
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ContainerStatus struct {
+	Name         string         `json:"name,omitempty"`
+	Ready        bool           `json:"ready,omitempty"`
+	RestartCount int            `json:"restartCount,omitempty"`
+	State        ContainerState `json:"state,omitempty"`
+}
+
+// ContainerState holds exactly one of its fields.
+type ContainerState struct {
+	Waiting    *ContainerStateWaiting    `json:"waiting,omitempty"`
+	Running    *ContainerStateRunning    `json:"running,omitempty"`
+	Terminated *ContainerStateTerminated `json:"terminated,omitempty"`
+}
+
+type ContainerStateWaiting struct {
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type ContainerStateRunning struct {
+	StartedAt time.Time `json:"startedAt,omitempty"`
+}
+
+type ContainerStateTerminated struct {
+	ExitCode   int       `json:"exitCode"`
+	Reason     string    `json:"reason,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	StartedAt  time.Time `json:"startedAt,omitempty"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+}
+
+// PodLog is the log of one of a job's pods.
+type PodLog struct {
+	StartTime time.Time
+	Log       []byte
+	// Missing is true if the pod's log is gone, for instance because the
+	// pod was garbage-collected.
+	Missing bool
+}
+
+// PodStatusSummary is the part of a pod's status worth showing at a glance.
+type PodStatusSummary struct {
+	Phase PodPhase
+	// Ready is true if the pod's Ready condition is true.
+	Ready      bool
+	Containers []ContainerSummary
+}
+
+type ContainerSummary struct {
+	Name         string
+	Ready        bool
+	RestartCount int
+	// State is "Waiting", "Running", or "Terminated".
+	State string
+	// Reason explains the state, such as "CrashLoopBackOff" or "Completed".
+	// It is empty for running containers.
+	Reason string
+}
+
+// Summary extracts the pod's phase, readiness, and container states.
+func (p *Pod) Summary() PodStatusSummary {
+	s := PodStatusSummary{Phase: p.Status.Phase}
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			s.Ready = c.Status == "True"
+		}
+	}
+	for _, cs := range p.Status.ContainerStatuses {
+		summary := ContainerSummary{
+			Name:         cs.Name,
+			Ready:        cs.Ready,
+			RestartCount: cs.RestartCount,
+		}
+		switch {
+		case cs.State.Waiting != nil:
+			summary.State = "Waiting"
+			summary.Reason = cs.State.Waiting.Reason
+		case cs.State.Running != nil:
+			summary.State = "Running"
+		case cs.State.Terminated != nil:
+			summary.State = "Terminated"
+			summary.Reason = cs.State.Terminated.Reason
+		}
+		s.Containers = append(s.Containers, summary)
+	}
+	return s
 }
 
 type Volume struct {
@@ -0,0 +1,315 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type WatchEventType string
+
+const (
+	Added    WatchEventType = "ADDED"
+	Modified WatchEventType = "MODIFIED"
+	Deleted  WatchEventType = "DELETED"
+	Bookmark WatchEventType = "BOOKMARK"
+	Error    WatchEventType = "ERROR"
+)
+
+// watchRetries is how many times in a row a watch may fail before it gives
+// up.
+const watchRetries = 5
+
+// PodEvent is a change to a watched pod.
+type PodEvent struct {
+	Type WatchEventType
+	Pod  Pod
+	// Err is set only on an Error event, which is the last event sent
+	// before the channel is closed.
+	Err error
+}
+
+// WatchFailedError is sent in an Error event when a watch can't be
+// restarted. Consumers should fall back to polling.
+type WatchFailedError struct {
+	Failures int
+	Err      error
+}
+
+func (e WatchFailedError) Error() string {
+	return fmt.Sprintf("watch failed %d times in a row, last error: %v", e.Failures, e.Err)
+}
+
+// watchEvent is a single event in a watch response stream.
+type watchEvent struct {
+	Type   WatchEventType  `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// initialEventsEndAnnotation marks the bookmark that ends the initial events
+// of a watch with sendInitialEvents.
+const initialEventsEndAnnotation = "k8s.io/initial-events-end"
+
+// errGone means that the resource version a watch started from is too old,
+// so the watch must be restarted from a fresh list.
+var errGone = errors.New("resource version is too old")
+
+// WatchPods sends an Added event for each pod matching labels, followed by
+// an event for each subsequent change to a matching pod. The channel is
+// closed once ctx is done.
+//
+// If the watch keeps failing, it retries with exponential backoff. After
+// watchRetries failures in a row it sends an Error event carrying a
+// WatchFailedError and closes the channel.
+//
+// The watch asks the api-server for bookmarks, so that if it disconnects it
+// can resume from the latest resource version without missing events. If
+// that version has expired, the pods are listed again and sent as Added
+// events, so consumers should treat Added as "add or update".
+func (c *Client) WatchPods(ctx context.Context, labels map[string]string) (<-chan PodEvent, error) {
+	return c.WatchPodsWithOptions(ctx, ListOptions{LabelSelector: labelsToSelector(labels)})
+}
+
+// WatchPodsWithOptions is like WatchPods but selects pods with the label and
+// field selectors of opts, which are sent on every list and watch request so
+// that only matching pods are delivered. If opts.TimeoutSeconds is set, the
+// api-server ends each watch connection after that long and the watch
+// reconnects, which periodically rebalances it across load-balanced
+// api-servers.
+//
+// If opts.ResourceVersion is set, the initial list is skipped and only
+// changes after that version are sent, for instance to resume from the last
+// version a consumer saw. If the version has expired, the pods are listed
+// again as usual. Limit and Continue are ignored.
+//
+// If opts.SendInitialEvents is set, the current pods arrive over the watch
+// itself instead of from a separate list, so that no change can slip in
+// between the two. They are sent as Added events followed by a Bookmark
+// event, which tells the consumer that its view of the pods is complete.
+// With opts.ResourceVersion the current pods are at least that new. If the
+// watch has to start over, the pods and the Bookmark are sent again. This
+// needs an api-server that supports streaming lists.
+func (c *Client) WatchPodsWithOptions(ctx context.Context, opts ListOptions) (<-chan PodEvent, error) {
+	c.log("WatchPodsWithOptions", opts)
+	w := &podWatcher{
+		client: c,
+		once:   c.WithoutRetries(),
+		opts: ListOptions{
+			LabelSelector:  opts.LabelSelector,
+			FieldSelector:  opts.FieldSelector,
+			TimeoutSeconds: opts.TimeoutSeconds,
+		},
+		events:          make(chan PodEvent),
+		resourceVersion: opts.ResourceVersion,
+		streamList:      opts.SendInitialEvents,
+		initialEvents:   opts.SendInitialEvents,
+	}
+	var pods []Pod
+	if w.resourceVersion == "" && !w.streamList {
+		var err error
+		if pods, err = w.list(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	go w.run(ctx, pods)
+	return w.events, nil
+}
+
+type podWatcher struct {
+	client *Client
+	// once makes the watches and relists, which are attempted once each so
+	// that watchRetries and the watcher's backoff alone bound the retries.
+	once   *Client
+	opts   ListOptions
+	events chan PodEvent
+
+	// resourceVersion is where the next watch starts. It is advanced by
+	// every event, including bookmarks, except initial events.
+	resourceVersion string
+	// streamList is set if the pods are sent as initial events instead of
+	// being listed.
+	streamList bool
+	// initialEvents is set until the next watch has sent all its initial
+	// events.
+	initialEvents bool
+}
+
+// list lists the pods with c and records the resource version to watch from.
+func (w *podWatcher) list(ctx context.Context, c *Client) ([]Pod, error) {
+	var pl struct {
+		Metadata ListMeta `json:"metadata"`
+		Items    []Pod    `json:"items"`
+	}
+	err := c.requestDecode(&request{
+		ctx:    ctx,
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods", c.namespace),
+		query:  w.opts.query(),
+	}, &pl)
+	if err != nil {
+		return nil, err
+	}
+	w.resourceVersion = pl.Metadata.ResourceVersion
+	return pl.Items, nil
+}
+
+func (w *podWatcher) run(ctx context.Context, pods []Pod) {
+	defer close(w.events)
+	backoff := w.client.retryDelay()
+	failures := 0
+	relist := false
+	for {
+		var err error
+		if relist && w.streamList {
+			w.resourceVersion = ""
+			w.initialEvents = true
+		} else if relist {
+			pods, err = w.list(ctx, w.once)
+		}
+		if err == nil {
+			relist = false
+			for _, pod := range pods {
+				if !w.send(ctx, PodEvent{Type: Added, Pod: pod}) {
+					return
+				}
+			}
+			pods = nil
+			var healthy bool
+			healthy, err = w.watch(ctx)
+			if healthy {
+				failures = 0
+				backoff = w.client.retryDelay()
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+		if err == errGone {
+			relist = true
+		}
+		failures++
+		if failures > watchRetries {
+			if w.client.Logger != nil {
+				w.client.Logger.Printf("Giving up on watch of pods in %s after %d failures: %v", w.client.namespace, failures, err)
+			}
+			w.send(ctx, PodEvent{Type: Error, Err: WatchFailedError{Failures: failures, Err: err}})
+			return
+		}
+		w.client.debugf("Watch of pods in %s stopped (failure %d/%d), restarting in %v: %v", w.client.namespace, failures, watchRetries, backoff, err)
+		if !w.sleep(ctx, backoff) {
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// watch follows a single watch connection until the api-server closes it.
+// It reports whether the connection was healthy, meaning that it delivered
+// an event or was closed cleanly, even if it then failed.
+func (w *podWatcher) watch(ctx context.Context) (bool, error) {
+	query := w.opts.query()
+	query["watch"] = "true"
+	query["allowWatchBookmarks"] = "true"
+	query["resourceVersion"] = w.resourceVersion
+	if w.initialEvents {
+		query["sendInitialEvents"] = "true"
+		query["resourceVersionMatch"] = "NotOlderThan"
+	}
+	body, err := w.once.requestRetryStream(&request{
+		ctx:    ctx,
+		method: http.MethodGet,
+		path:   fmt.Sprintf("/api/v1/namespaces/%s/pods", w.client.namespace),
+		query:  query,
+		stream: true,
+	})
+	if se, ok := err.(StatusError); ok && se.Code == http.StatusGone {
+		return false, errGone
+	} else if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	healthy := false
+	for {
+		var e watchEvent
+		if err := dec.Decode(&e); err == io.EOF {
+			// The api-server ends watches from time to time, which
+			// just means it is time to reconnect.
+			return true, nil
+		} else if err != nil {
+			return healthy, err
+		}
+		if e.Type == Error {
+			var status Status
+			if err := json.Unmarshal(e.Object, &status); err == nil && status.Code == http.StatusGone {
+				return healthy, errGone
+			}
+			return healthy, fmt.Errorf("watch error: %s", string(e.Object))
+		}
+		healthy = true
+		var pod Pod
+		if err := json.Unmarshal(e.Object, &pod); err != nil {
+			return healthy, err
+		}
+		if e.Type == Bookmark && w.initialEvents && pod.Metadata.Annotations[initialEventsEndAnnotation] == "true" {
+			w.initialEvents = false
+			w.resourceVersion = pod.Metadata.ResourceVersion
+			if !w.send(ctx, PodEvent{Type: Bookmark, Pod: pod}) {
+				return healthy, ctx.Err()
+			}
+			continue
+		}
+		// Until the initial events end, a reconnect has to start over.
+		if pod.Metadata.ResourceVersion != "" && !w.initialEvents {
+			w.resourceVersion = pod.Metadata.ResourceVersion
+		}
+		if e.Type == Bookmark {
+			continue
+		}
+		if !w.send(ctx, PodEvent{Type: e.Type, Pod: pod}) {
+			return healthy, ctx.Err()
+		}
+	}
+}
+
+func (w *podWatcher) send(ctx context.Context, e PodEvent) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *podWatcher) sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-w.client.clock().After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
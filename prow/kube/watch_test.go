@@ -0,0 +1,433 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchServer serves pod lists and watches from scripted responses. Each
+// list or watch request consumes the next response of its kind. Once the
+// watch responses run out, watches block until the test finishes.
+type watchServer struct {
+	*httptest.Server
+
+	sync.Mutex
+	lists   []string
+	watches []func(w http.ResponseWriter)
+	// requests records the query of each request, in order.
+	requests []string
+	done     chan struct{}
+}
+
+func newWatchServer(t *testing.T, lists []string, watches []func(w http.ResponseWriter)) *watchServer {
+	ws := &watchServer{lists: lists, watches: watches, done: make(chan struct{})}
+	ws.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/ns/pods" {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+		ws.Lock()
+		ws.requests = append(ws.requests, r.URL.RawQuery)
+		if r.URL.Query().Get("watch") != "true" {
+			if len(ws.lists) == 0 {
+				ws.Unlock()
+				t.Errorf("Unexpected list: %s", r.URL.RawQuery)
+				return
+			}
+			list := ws.lists[0]
+			ws.lists = ws.lists[1:]
+			ws.Unlock()
+			fmt.Fprint(w, list)
+			return
+		}
+		if len(ws.watches) == 0 {
+			ws.Unlock()
+			w.(http.Flusher).Flush()
+			<-ws.done
+			return
+		}
+		watch := ws.watches[0]
+		ws.watches = ws.watches[1:]
+		ws.Unlock()
+		watch(w)
+	}))
+	return ws
+}
+
+func (ws *watchServer) Close() {
+	close(ws.done)
+	ws.Server.Close()
+}
+
+func (ws *watchServer) watchQueries() []string {
+	ws.Lock()
+	defer ws.Unlock()
+	var qs []string
+	for _, q := range ws.requests {
+		if v, _ := url.ParseQuery(q); v.Get("watch") == "true" {
+			qs = append(qs, v.Get("resourceVersion"))
+		}
+	}
+	return qs
+}
+
+func receive(t *testing.T, events <-chan PodEvent, n int) []string {
+	var got []string
+	for i := 0; i < n; i++ {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("Channel closed after %v", got)
+			}
+			got = append(got, fmt.Sprintf("%s %s", e.Type, e.Pod.Metadata.Name))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out after %v", got)
+		}
+	}
+	return got
+}
+
+func TestWatchPodsBookmark(t *testing.T) {
+	ws := newWatchServer(t,
+		[]string{`{"metadata": {"resourceVersion": "1"}, "items": [{"metadata": {"name": "a"}}]}`},
+		[]func(w http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "7"}}}`)
+			},
+			func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"type": "MODIFIED", "object": {"metadata": {"name": "a", "resourceVersion": "8"}}}`)
+			},
+		})
+	defer ws.Close()
+	c := getClient(ws.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchPods(ctx, nil)
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	got := receive(t, events, 2)
+	if expected := []string{"ADDED a", "MODIFIED a"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %v, got %v", expected, got)
+	}
+	if qs := ws.watchQueries(); !reflect.DeepEqual(qs[:2], []string{"1", "7"}) {
+		t.Errorf("Expected watches from resource versions 1 then 7, got %v", qs)
+	}
+	ws.Lock()
+	for _, q := range ws.requests {
+		if v, _ := url.ParseQuery(q); v.Get("watch") == "true" && v.Get("allowWatchBookmarks") != "true" {
+			t.Errorf("Expected bookmarks to be requested: %s", q)
+		}
+	}
+	ws.Unlock()
+}
+
+func TestWatchPodsGone(t *testing.T) {
+	var testcases = []struct {
+		name string
+		gone func(w http.ResponseWriter)
+	}{
+		{
+			name: "410 response",
+			gone: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusGone)
+				fmt.Fprint(w, `{"kind": "Status", "reason": "Expired", "code": 410}`)
+			},
+		},
+		{
+			name: "410 error event",
+			gone: func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"type": "ERROR", "object": {"kind": "Status", "reason": "Expired", "code": 410}}`)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		ws := newWatchServer(t,
+			[]string{
+				`{"metadata": {"resourceVersion": "1"}, "items": [{"metadata": {"name": "a"}}]}`,
+				`{"metadata": {"resourceVersion": "5"}, "items": [{"metadata": {"name": "b"}}]}`,
+			},
+			[]func(w http.ResponseWriter){
+				tc.gone,
+				func(w http.ResponseWriter) {
+					fmt.Fprint(w, `{"type": "ADDED", "object": {"metadata": {"name": "c", "resourceVersion": "6"}}}`)
+				},
+			})
+		c := getClient(ws.URL)
+		c.initialBackoff = time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := c.WatchPods(ctx, nil)
+		if err != nil {
+			t.Fatalf("%s: didn't expect error: %v", tc.name, err)
+		}
+		got := receive(t, events, 3)
+		if expected := []string{"ADDED a", "ADDED b", "ADDED c"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected events %v, got %v", tc.name, expected, got)
+		}
+		if qs := ws.watchQueries(); !reflect.DeepEqual(qs[:2], []string{"1", "5"}) {
+			t.Errorf("%s: expected watches from resource versions 1 then 5, got %v", tc.name, qs)
+		}
+		cancel()
+		for range events {
+		}
+		ws.Close()
+	}
+}
+
+func TestWatchPodsTimeoutSeconds(t *testing.T) {
+	ws := newWatchServer(t,
+		[]string{`{"metadata": {"resourceVersion": "1"}, "items": []}`},
+		[]func(w http.ResponseWriter){
+			// The api-server ends the watch once timeoutSeconds pass.
+			func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"type": "ADDED", "object": {"metadata": {"name": "a", "resourceVersion": "2"}}}`)
+			},
+			func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"type": "DELETED", "object": {"metadata": {"name": "a", "resourceVersion": "3"}}}`)
+			},
+		})
+	defer ws.Close()
+	c := getClient(ws.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchPodsWithOptions(ctx, ListOptions{LabelSelector: "app = deck", TimeoutSeconds: 60})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	got := receive(t, events, 2)
+	if expected := []string{"ADDED a", "DELETED a"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %v, got %v", expected, got)
+	}
+	if qs := ws.watchQueries(); !reflect.DeepEqual(qs[:2], []string{"1", "2"}) {
+		t.Errorf("Expected a reconnect from resource version 2, got %v", qs)
+	}
+	ws.Lock()
+	defer ws.Unlock()
+	for _, q := range ws.requests {
+		v, _ := url.ParseQuery(q)
+		if v.Get("timeoutSeconds") != "60" {
+			t.Errorf("Expected timeoutSeconds on every request: %s", q)
+		}
+		if v.Get("labelSelector") != "app = deck" {
+			t.Errorf("Expected the label selector on every request: %s", q)
+		}
+	}
+}
+
+// dropWatch ends the watch partway through an event, as when the connection
+// is lost.
+func dropWatch(w http.ResponseWriter) {
+	fmt.Fprint(w, `{"type": "ADDED", "obj`)
+}
+
+func TestWatchPodsGivesUp(t *testing.T) {
+	var watches []func(w http.ResponseWriter)
+	for i := 0; i <= watchRetries; i++ {
+		watches = append(watches, dropWatch)
+	}
+	ws := newWatchServer(t, []string{`{"metadata": {"resourceVersion": "1"}, "items": []}`}, watches)
+	defer ws.Close()
+	c := getClient(ws.URL)
+	c.initialBackoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchPods(ctx, nil)
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("Channel closed without an error event.")
+		}
+		if e.Type != Error {
+			t.Fatalf("Expected an error event, got %s", e.Type)
+		}
+		if wf, ok := e.Err.(WatchFailedError); !ok || wf.Failures != watchRetries+1 {
+			t.Errorf("Expected a WatchFailedError after %d failures, got %v", watchRetries+1, e.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the watch to give up.")
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected the channel to be closed after the error event.")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the channel to close.")
+	}
+	if n := len(ws.watchQueries()); n != watchRetries+1 {
+		t.Errorf("Expected %d watch attempts, got %d", watchRetries+1, n)
+	}
+}
+func TestWatchPodsConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	c := getClient("http://" + addr)
+	clock := &fakeClock{now: time.Now()}
+	c.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchPodsWithOptions(ctx, ListOptions{ResourceVersion: "1"})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	select {
+	case e := <-events:
+		if wf, ok := e.Err.(WatchFailedError); e.Type != Error || !ok || wf.Failures != watchRetries+1 {
+			t.Errorf("Expected a WatchFailedError after %d failures, got %+v", watchRetries+1, e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the watch to give up.")
+	}
+	// Only the watcher backs off, not each connection attempt.
+	clock.Lock()
+	defer clock.Unlock()
+	if len(clock.waited) != watchRetries {
+		t.Errorf("Expected %d backoffs, got %v", watchRetries, clock.waited)
+	}
+}
+
+func TestWatchPodsRecovers(t *testing.T) {
+	var watches []func(w http.ResponseWriter)
+	// Failures only count while they are consecutive.
+	for i := 0; i < watchRetries; i++ {
+		watches = append(watches, dropWatch)
+	}
+	watches = append(watches, func(w http.ResponseWriter) {
+		fmt.Fprint(w, `{"type": "ADDED", "object": {"metadata": {"name": "a", "resourceVersion": "2"}}}`)
+		dropWatch(w)
+	})
+	// The drop that ended the healthy watch is the first of these failures.
+	for i := 0; i < watchRetries-1; i++ {
+		watches = append(watches, dropWatch)
+	}
+	watches = append(watches, func(w http.ResponseWriter) {
+		fmt.Fprint(w, `{"type": "DELETED", "object": {"metadata": {"name": "a", "resourceVersion": "3"}}}`)
+	})
+	ws := newWatchServer(t, []string{`{"metadata": {"resourceVersion": "1"}, "items": []}`}, watches)
+	defer ws.Close()
+	c := getClient(ws.URL)
+	c.initialBackoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchPods(ctx, nil)
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	got := receive(t, events, 2)
+	if expected := []string{"ADDED a", "DELETED a"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %v, got %v", expected, got)
+	}
+}
+
+func TestWatchPodsFromResourceVersion(t *testing.T) {
+	ws := newWatchServer(t, nil, []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			fmt.Fprint(w, `{"type": "MODIFIED", "object": {"metadata": {"name": "a", "resourceVersion": "6"}}}`)
+		},
+	})
+	defer ws.Close()
+	c := getClient(ws.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchPodsWithOptions(ctx, ListOptions{LabelSelector: "created-by-prow = true", ResourceVersion: "5"})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	got := receive(t, events, 1)
+	if expected := []string{"MODIFIED a"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %v, got %v", expected, got)
+	}
+	if qs := ws.watchQueries(); qs[0] != "5" {
+		t.Errorf("Expected the watch to start from resource version 5, got %v", qs)
+	}
+	ws.Lock()
+	defer ws.Unlock()
+	for _, q := range ws.requests {
+		v, _ := url.ParseQuery(q)
+		if v.Get("watch") != "true" {
+			t.Errorf("Expected no list when starting from a resource version: %s", q)
+		}
+		if v.Get("labelSelector") != "created-by-prow = true" {
+			t.Errorf("Expected the label selector on every watch: %s", q)
+		}
+	}
+}
+
+func TestWatchPodsSendInitialEvents(t *testing.T) {
+	ws := newWatchServer(t, nil, []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			// Ends before the initial events do, so the next watch
+			// starts over.
+			fmt.Fprint(w, `{"type": "ADDED", "object": {"metadata": {"name": "a", "resourceVersion": "3"}}}`)
+		},
+		func(w http.ResponseWriter) {
+			fmt.Fprint(w, `{"type": "ADDED", "object": {"metadata": {"name": "a", "resourceVersion": "3"}}}`)
+			fmt.Fprint(w, `{"type": "ADDED", "object": {"metadata": {"name": "b", "resourceVersion": "4"}}}`)
+			fmt.Fprint(w, `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "5", "annotations": {"k8s.io/initial-events-end": "true"}}}}`)
+			fmt.Fprint(w, `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "6"}}}`)
+			fmt.Fprint(w, `{"type": "MODIFIED", "object": {"metadata": {"name": "a", "resourceVersion": "7"}}}`)
+		},
+	})
+	defer ws.Close()
+	c := getClient(ws.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchPodsWithOptions(ctx, ListOptions{SendInitialEvents: true})
+	if err != nil {
+		t.Fatalf("Didn't expect error: %v", err)
+	}
+	got := receive(t, events, 5)
+	if expected := []string{"ADDED a", "ADDED a", "ADDED b", "BOOKMARK ", "MODIFIED a"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %v, got %v", expected, got)
+	}
+	// The watch reconnects after the last event is sent.
+	qs := ws.watchQueries()
+	for deadline := time.Now().Add(5 * time.Second); len(qs) < 3 && time.Now().Before(deadline); qs = ws.watchQueries() {
+		time.Sleep(time.Millisecond)
+	}
+	if len(qs) < 3 || !reflect.DeepEqual(qs[:3], []string{"", "", "7"}) {
+		t.Errorf("Expected watches from resource versions \"\", \"\", then 7, got %v", qs)
+	}
+	ws.Lock()
+	defer ws.Unlock()
+	for i, q := range ws.requests {
+		v, _ := url.ParseQuery(q)
+		if v.Get("watch") != "true" {
+			t.Errorf("Expected no list with initial events: %s", q)
+		}
+		initial := i < 2
+		if (v.Get("sendInitialEvents") == "true") != initial || (v.Get("resourceVersionMatch") == "NotOlderThan") != initial {
+			t.Errorf("Expected initial events to be requested %t for watch %d: %s", initial, i, q)
+		}
+	}
+}